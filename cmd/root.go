@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"time"
 
 	gorillahandlers "github.com/gorilla/handlers"
 	"github.com/rs/zerolog"
@@ -50,6 +51,23 @@ var (
 	humanReadable bool
 	debug         bool
 	logJournald   bool
+	fileCacheSize int64
+	fileCacheMax  int64
+	diskCacheDir  string
+	diskCacheSize int64
+	statikStale   time.Duration
+	statikSize    int64
+	zipMaxSize    int64
+	linkFormat    string
+	httpConfig    = fs.DefaultHTTPConfig
+	s3Config      = fs.DefaultS3Config
+	gitConfig     = fs.DefaultGitConfig
+	zipConfig     = handlers.DefaultZipConfig
+
+	fixedLinkFormat fs.LinkFormat // fixed format of the links, nil to choose it by User-Agent
+	contentCache    *fs.ContentCache
+	statikCache     *fs.StatikCache
+	diskCache       *fs.DiskCache
 )
 
 func init() {
@@ -61,8 +79,39 @@ func init() {
 	RootCmd.Flags().BoolVar(&humanReadable, "human", false, "enable human readable output")
 	RootCmd.Flags().BoolVarP(&debug, "debug", "d", false, "enable debug output")
 	RootCmd.Flags().BoolVar(&logJournald, "journald", false, "enable logJournald output")
+	RootCmd.Flags().Int64Var(&fileCacheSize, "filecache", fs.DefaultContentCacheSize>>20, "maximum size of cached file contents, in MiB")
+	RootCmd.Flags().Int64Var(&fileCacheMax, "filecachemax", fs.DefaultContentCacheObject>>20, "maximum size of a single cached file, in MiB")
+	RootCmd.Flags().Int64Var(&statikSize, "statikcache", fs.DefaultStatikCacheSize>>20, "maximum size of cached statik.json files, in MiB")
+	RootCmd.Flags().DurationVar(&statikStale, "statikstale", fs.StatikStaleTime, "how long to serve expired statik.json files while refreshing them or while the upstream fails")
+	RootCmd.Flags().StringVar(&diskCacheDir, "diskcache", "", "directory of the persistent file cache (disabled if empty)")
+	RootCmd.Flags().Int64Var(&diskCacheSize, "diskcachesize", fs.DefaultDiskCacheSize>>20, "maximum size of the persistent file cache, in MiB")
+
+	RootCmd.Flags().DurationVar(&httpConfig.ConnectTimeout, "connecttimeout", fs.DefaultHTTPConfig.ConnectTimeout, "timeout for connecting to the upstream server")
+	RootCmd.Flags().DurationVar(&httpConfig.HeaderTimeout, "headertimeout", fs.DefaultHTTPConfig.HeaderTimeout, "timeout for receiving the response headers from the upstream server")
+	RootCmd.Flags().DurationVar(&httpConfig.ReadTimeout, "readtimeout", fs.DefaultHTTPConfig.ReadTimeout, "timeout for receiving more of a response body from the upstream server")
+	RootCmd.Flags().IntVar(&httpConfig.Retries, "retries", fs.DefaultHTTPConfig.Retries, "how many times failed requests to the upstream server are retried")
+	RootCmd.Flags().DurationVar(&httpConfig.RetryBackoff, "retrybackoff", fs.DefaultHTTPConfig.RetryBackoff, "delay before the first retry, doubled at each retry up to a minute")
+	RootCmd.Flags().IntVar(&httpConfig.MaxConnsPerHost, "maxconns", fs.DefaultHTTPConfig.MaxConnsPerHost, "maximum number of connections per upstream host (0 for no limit)")
+	RootCmd.Flags().IntVar(&httpConfig.BreakerThreshold, "breakerthreshold", fs.DefaultHTTPConfig.BreakerThreshold, "consecutive upstream failures opening the circuit breaker of a host (0 to disable)")
+	RootCmd.Flags().DurationVar(&httpConfig.BreakerCooldown, "breakercooldown", fs.DefaultHTTPConfig.BreakerCooldown, "how long an open circuit breaker fails requests before probing the upstream host again")
+	RootCmd.Flags().StringVar(&httpConfig.UserAgent, "useragent", fs.DefaultHTTPConfig.UserAgent, "User-Agent of the requests to the upstream server")
+
+	RootCmd.Flags().StringVar(&s3Config.Endpoint, "s3endpoint", fs.DefaultS3Config.Endpoint, "url of the S3 API for s3:// base urls")
+	RootCmd.Flags().StringVar(&s3Config.Region, "s3region", fs.DefaultS3Config.Region, "region of the S3 buckets")
+	RootCmd.Flags().BoolVar(&s3Config.PathStyle, "s3pathstyle", fs.DefaultS3Config.PathStyle, "address S3 buckets in the path instead of the host name")
+	RootCmd.Flags().StringVar(&s3Config.AccessKey, "s3accesskey", "", "S3 access key id (default $AWS_ACCESS_KEY_ID, anonymous if empty)")
+	RootCmd.Flags().StringVar(&s3Config.SecretKey, "s3secretkey", "", "S3 secret access key (default $AWS_SECRET_ACCESS_KEY)")
+
+	RootCmd.Flags().StringVar(&gitConfig.CacheDir, "gitcache", fs.DefaultGitConfig.CacheDir, "directory of the mirrors of the git repositories")
+	RootCmd.Flags().StringVar(&gitConfig.Ref, "gitref", fs.DefaultGitConfig.Ref, "git ref served at the root of the teachings (others are under /@ref/)")
+	RootCmd.Flags().DurationVar(&gitConfig.RefreshInterval, "gitrefresh", fs.DefaultGitConfig.RefreshInterval, "how often the git repositories are fetched again")
 
-	RootCmd.Flags().StringVarP(&basePath, "basepath", "b", "", "base path for the static files")
+	RootCmd.Flags().Int64Var(&zipMaxSize, "zipmaxsize", handlers.DefaultZipConfig.MaxSize>>20, "maximum total size of a directory downloaded as zip, in MiB")
+	RootCmd.Flags().IntVar(&zipConfig.MaxFiles, "zipmaxfiles", handlers.DefaultZipConfig.MaxFiles, "maximum number of files of a directory downloaded as zip")
+
+	RootCmd.Flags().StringVar(&linkFormat, "linkformat", "auto", "format of the files served for links: desktop, url (Windows), webloc (macOS), html, or auto to choose it by User-Agent")
+
+	RootCmd.Flags().StringVarP(&basePath, "basepath", "b", "", "base url of the static files: http(s)://, file:// for a local statik tree, dir:// for a plain local directory, s3://bucket/prefix or git+file:// for local git repositories")
 	_ = RootCmd.MarkFlagRequired("basepath")
 }
 
@@ -117,6 +166,39 @@ func Execute(*cobra.Command, []string) {
 
 	logger := handlers.ZerologWebdavLogger(log.Logger, zerolog.InfoLevel)
 
+	if err := fs.ConfigureHTTP(httpConfig); err != nil {
+		log.Fatal().Err(err).Msg("invalid upstream HTTP configuration")
+	}
+
+	if s3Config.AccessKey == "" {
+		s3Config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if s3Config.SecretKey == "" {
+		s3Config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	s3Config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	fs.ConfigureS3(s3Config)
+	fs.ConfigureGit(gitConfig)
+	zipConfig.MaxSize = zipMaxSize << 20
+
+	if linkFormat != "auto" {
+		fixedLinkFormat, err = fs.ParseLinkFormat(linkFormat)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid --linkformat")
+		}
+	}
+
+	contentCache = fs.NewContentCache(fileCacheSize<<20, fileCacheMax<<20)
+	statikCache = fs.NewStatikCache(statikSize<<20, statikStale)
+
+	if diskCacheDir != "" {
+		log.Info().Str("dir", diskCacheDir).Msg("loading disk cache")
+		diskCache, err = fs.NewDiskCache(diskCacheDir, diskCacheSize<<20)
+		if err != nil {
+			log.Fatal().Err(err).Str("dir", diskCacheDir).Msg("error loading disk cache")
+		}
+	}
+
 	mux := http.NewServeMux()
 
 	teachings := make([]string, 0, len(config))
@@ -155,7 +237,18 @@ func Execute(*cobra.Command, []string) {
 }
 
 func handleTeaching(mux *http.ServeMux, url string, logger func(req *http.Request, err error)) {
-	statikFS, err := fs.NewStatikFS(basePath + url)
+	opts := []fs.Option{
+		fs.WithContentCache(contentCache),
+		fs.WithStatikCache(statikCache),
+	}
+	if diskCache != nil {
+		opts = append(opts, fs.WithDiskCache(diskCache))
+	}
+	if fixedLinkFormat != nil {
+		opts = append(opts, fs.WithLinkFormat(fixedLinkFormat))
+	}
+
+	statikFS, err := fs.NewStatikFS(basePath+url, opts...)
 	if err != nil {
 		log.Fatal().Err(err).Str("url", url).Msg("error creating statik fs")
 	}
@@ -167,5 +260,10 @@ func handleTeaching(mux *http.ServeMux, url string, logger func(req *http.Reques
 		Logger:     logger,
 	}
 
-	mux.Handle("/"+url+"/", handler)
+	var h http.Handler = handlers.ZipDownload(handler, statikFS, "/"+url, zipConfig)
+	if fixedLinkFormat == nil {
+		h = handlers.LinkFormatByUserAgent(h)
+	}
+
+	mux.Handle("/"+url+"/", handlers.UpstreamStatus(h))
 }
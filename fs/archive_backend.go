@@ -0,0 +1,428 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const maxArchiveEntries = 100000 // maximum number of entries of a browsable archive
+
+// archiveFormat is the format of a browsable archive, also used to mark the
+// urls of its members.
+type archiveFormat string
+
+const (
+	archiveNone  archiveFormat = ""
+	archiveZip   archiveFormat = "zip"
+	archiveTar   archiveFormat = "tar"
+	archiveTarGz archiveFormat = "tgz"
+)
+
+// archiveFormatOf returns the format of the archive called name, from its
+// extension, or archiveNone if name is not a browsable archive.
+func archiveFormatOf(name string) archiveFormat {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	}
+	return archiveNone
+}
+
+// memberUrl returns the url of the member name of the archive at archiveUrl.
+func memberUrl(archiveUrl string, format archiveFormat, name string) string {
+	return archiveUrl + "!" + string(format) + "/" + name
+}
+
+// isMember reports whether file is a member of an archive, listed by an
+// archiveBackend.
+func isMember(file StatikFileInfo) bool {
+	return file.archive != archiveNone
+}
+
+// memberName returns the slash-separated path of the member of an archive
+// called name, relative to the root of the archive, or "" if it is the root.
+func memberName(name string) string {
+	return strings.TrimPrefix(pathpkg.Clean("/"+name), "/")
+}
+
+// archiveBackend is the Backend of a StatikFS, browsing the zip and tar
+// archives of another Backend as directories.
+//
+// The listing of a path inside an archive, such as /esercizi.zip/2023, is
+// synthesized from the index of the archive: the central directory of zip
+// archives, read with range reads, or the headers of tar archives, read from
+// the start. All the directories of an archive are cached at once when it is
+// indexed, and they are revalidated against the listing of the directory of
+// the archive, so that the index is only read again when the archive changes.
+//
+// Members of archives are files marked with the format of their archive, whose
+// url is the one of the archive followed by "!" and the format of the archive.
+// They are found in the cached index of the archive, and read with a range read of their contents, except for the
+// members of compressed tar archives, which are read from the start of the
+// archive.
+type archiveBackend struct {
+	Backend
+	cache   *statikCache      // cache of the StatikFS, holding the listings of the archives
+	indexes archiveIndexCache // indexes of the archives read last
+}
+
+// List implements Backend for archiveBackend.
+func (b *archiveBackend) List(ctx context.Context, path string, prev Validators) (Statik, Validators, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	// in nested archives, the innermost one is listed
+	for i := len(segments) - 1; i >= 0; i-- {
+		if archiveFormatOf(segments[i]) == archiveNone {
+			continue
+		}
+
+		dir := "/" + strings.Join(segments[:i], "/")
+		parent, err := b.cache.Get(ctx, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return Statik{}, Validators{}, err
+		}
+
+		file, found := parent.file(segments[i])
+		if found && !isLink(file) {
+			archivePath := pathpkg.Join(dir, segments[i])
+			return b.listArchive(ctx, archivePath, file, path, prev)
+		}
+	}
+
+	return b.Backend.List(ctx, path, prev)
+}
+
+// listArchive returns the listing of the directory at path, inside the archive
+// at archivePath described by archive.
+func (b *archiveBackend) listArchive(ctx context.Context, archivePath string, archive StatikFileInfo, path string, prev Validators) (Statik, Validators, error) {
+	validators := archiveVersion(archive)
+	if prev == validators {
+		return Statik{}, prev, ErrNotModified
+	}
+
+	idx, err := b.index(ctx, archive)
+	if err != nil {
+		return Statik{}, Validators{}, b.archiveError(ctx, archivePath, err)
+	}
+
+	statiks := archiveStatiks(archivePath, archive, idx.entries)
+	for dir, statik := range statiks {
+		if dir != path {
+			b.cache.put(dir, statik, validators)
+		}
+	}
+
+	statik, found := statiks[path]
+	if !found {
+		return Statik{}, Validators{}, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return statik, validators, nil
+}
+
+// archiveError returns the error to report when the archive at archivePath
+// can't be read because of err: archives that are not valid are not browsable,
+// as if they didn't exist.
+func (b *archiveBackend) archiveError(ctx context.Context, archivePath string, err error) error {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) || ctx.Err() != nil {
+		return err
+	}
+
+	log.Warn().Err(err).Str("path", archivePath).Msg("archive not browsable")
+	return &fs.PathError{Op: "open", Path: archivePath, Err: fs.ErrNotExist}
+}
+
+// archiveStatiks returns the listings of all the directories of the archive at
+// archivePath described by archive, whose entries are entries, by path.
+func archiveStatiks(archivePath string, archive StatikFileInfo, entries []archiveEntry) map[string]Statik {
+	format := archiveFormatOf(archive.Name())
+	statiks := map[string]*Statik{}
+
+	times := map[string]time.Time{}
+	for _, entry := range entries {
+		if entry.dir && !entry.time.IsZero() {
+			times[entry.name] = entry.time
+		}
+	}
+
+	// dir returns the listing of the directory name of the archive, creating
+	// it and its parents if needed
+	var dir func(name string) *Statik
+	dir = func(name string) *Statik {
+		if statik, found := statiks[name]; found {
+			return statik
+		}
+
+		info := StatikDirInfo{
+			Url:     memberUrl(archive.Url, format, name),
+			Time:    archive.Time,
+			NameRaw: pathpkg.Base(pathpkg.Join(archivePath, name)),
+			Path:    pathpkg.Join(archivePath, name),
+			SizeRaw: "0 B",
+		}
+		if t, found := times[name]; found {
+			info.Time = t
+		}
+		statik := &Statik{StatikDirInfo: info}
+		statiks[name] = statik
+
+		if name != "" {
+			parent := dir(memberName(pathpkg.Dir(name)))
+			parent.Directories = append(parent.Directories, info)
+		}
+		return statik
+	}
+
+	dir("")
+	for _, entry := range entries {
+		if entry.name == "" {
+			continue
+		} else if entry.dir {
+			dir(entry.name)
+			continue
+		}
+
+		parent := dir(memberName(pathpkg.Dir(entry.name)))
+		parent.Files = append(parent.Files, StatikFileInfo{
+			NameRaw: pathpkg.Base(entry.name),
+			Path:    pathpkg.Join(archivePath, entry.name),
+			Url:     memberUrl(archive.Url, format, entry.name),
+			Mime:    mimeByExtension(entry.name),
+			SizeRaw: fmt.Sprintf("%d B", entry.size),
+			Time:    entry.time,
+			archive: format,
+		})
+	}
+
+	byPath := make(map[string]Statik, len(statiks))
+	for name, statik := range statiks {
+		byPath[pathpkg.Join(archivePath, name)] = *statik
+	}
+	return byPath
+}
+
+// Stat implements Backend for archiveBackend.
+func (b *archiveBackend) Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error) {
+	if !isMember(file) {
+		return b.Backend.Stat(ctx, file)
+	}
+
+	_, entry, err := b.member(ctx, file)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Validators: Validators{ETag: entry.etag}, Size: entry.size}, nil
+}
+
+// Open implements Backend for archiveBackend. The contents of the members of
+// compressed archives before offset are read and discarded.
+func (b *archiveBackend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	if !isMember(file) {
+		return b.Backend.Open(ctx, file, offset, length, prev)
+	}
+
+	idx, entry, err := b.member(ctx, file)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{Validators: Validators{ETag: entry.etag}, Size: entry.size}
+	if !prev.IsZero() && prev.ETag == info.ETag {
+		return nil, info, ErrNotModified
+	}
+
+	if offset > entry.size {
+		offset = entry.size
+	}
+	if length < 0 || offset+length > entry.size {
+		length = entry.size - offset
+	}
+	info.Offset = offset
+
+	if length == 0 {
+		return http.NoBody, info, nil
+	}
+
+	body, err := b.openMember(ctx, idx, entry, offset, length)
+	if err != nil {
+		return nil, ObjectInfo{}, b.archiveError(ctx, idx.archive.Url, err)
+	}
+	return body, info, nil
+}
+
+// member returns the index of the archive of file, a member of an archive, and
+// the entry of file in it. The archive is the file of the listings at the
+// closest parent of the path of file whose url is the one of file without the
+// name of the member.
+func (b *archiveBackend) member(ctx context.Context, file StatikFileInfo) (*archiveIndex, archiveEntry, error) {
+	// in nested archives, the innermost one
+	for archivePath := pathpkg.Dir(file.Path); archivePath != "/" && archivePath != "."; archivePath = pathpkg.Dir(archivePath) {
+		if archiveFormatOf(pathpkg.Base(archivePath)) != file.archive {
+			continue
+		}
+
+		parent, err := b.cache.Get(ctx, pathpkg.Dir(archivePath))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, archiveEntry{}, err
+		}
+
+		name := strings.TrimPrefix(file.Path, archivePath+"/")
+		archive, found := parent.file(pathpkg.Base(archivePath))
+		if !found || isLink(archive) || memberUrl(archive.Url, file.archive, name) != file.Url {
+			continue
+		}
+
+		idx, err := b.index(ctx, archive)
+		if err != nil {
+			return nil, archiveEntry{}, b.archiveError(ctx, archivePath, err)
+		}
+
+		entry, found := idx.file(name)
+		if !found {
+			break
+		}
+		return idx, entry, nil
+	}
+
+	return nil, archiveEntry{}, &fs.PathError{Op: "open", Path: file.Url, Err: fs.ErrNotExist}
+}
+
+// openMember opens the contents of entry of the archive of idx, from offset
+// and up to length bytes, which must be within the contents and not empty.
+func (b *archiveBackend) openMember(ctx context.Context, idx *archiveIndex, entry archiveEntry, offset, length int64) (io.ReadCloser, error) {
+	switch idx.format {
+	case archiveTar:
+		return openRange(ctx, b, idx.archive, entry.offset+offset, length)
+
+	case archiveTarGz:
+		body, err := b.openTar(ctx, idx.archive, idx.format)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.CopyN(io.Discard, body.stream, entry.offset+offset); err != nil {
+			body.Close()
+			return nil, err
+		}
+		return readCloser{Reader: io.LimitReader(body.stream, length), Closer: body}, nil
+	}
+
+	dataOffset, err := idx.dataOffset(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	switch entry.file.Method {
+	case zip.Store:
+		return openRange(ctx, b, idx.archive, dataOffset+offset, length)
+	case zip.Deflate:
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+
+	body, err := openRange(ctx, b, idx.archive, dataOffset, int64(entry.file.CompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = &crcReader{
+		Reader: io.LimitReader(flate.NewReader(body), entry.size),
+		hash:   crc32.NewIEEE(),
+		crc:    entry.file.CRC32,
+	}
+	if _, err = io.CopyN(io.Discard, r, offset); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return readCloser{Reader: io.LimitReader(r, length), Closer: body}, nil
+}
+
+// crcReader reads Reader, failing with zip.ErrChecksum at its end if the CRC-32
+// of its contents is not crc.
+type crcReader struct {
+	io.Reader
+	hash hash.Hash32
+	crc  uint32
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && r.hash.Sum32() != r.crc {
+		err = zip.ErrChecksum
+	}
+	return n, err
+}
+
+// countingReader is an io.Reader counting the bytes read from Reader.
+type countingReader struct {
+	io.Reader
+	n int64 // bytes read so far
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// tarFile is a tar archive being read, with the body it is read from.
+type tarFile struct {
+	*tar.Reader
+	stream *countingReader // tar stream, decompressed for archiveTarGz
+	body   io.ReadCloser
+}
+
+func (f tarFile) Close() error { return f.body.Close() }
+
+// openTar opens the tar archive described by archive, compressed with gzip if
+// format is archiveTarGz. It is read from the start.
+func (b *archiveBackend) openTar(ctx context.Context, archive StatikFileInfo, format archiveFormat) (tarFile, error) {
+	body, _, err := b.Open(ctx, archive, 0, -1, Validators{})
+	if err != nil {
+		return tarFile{}, err
+	}
+
+	var r io.Reader = body
+	if format == archiveTarGz {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			body.Close()
+			return tarFile{}, err
+		}
+		r = gz
+	}
+
+	stream := &countingReader{Reader: r}
+	return tarFile{Reader: tar.NewReader(stream), stream: stream, body: body}, nil
+}
+
+// readCloser is an io.ReadCloser reading from Reader and closing Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
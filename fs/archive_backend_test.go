@@ -0,0 +1,290 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newZip returns a zip archive of files, contents by name. Files ending with
+// .bin are stored, the others deflated.
+func newZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range sortedKeys(files) {
+		method := zip.Deflate
+		if strings.HasSuffix(name, ".bin") {
+			method = zip.Store
+		}
+
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Unix(1e9, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+// newTar returns a tar archive of files, contents by name, compressed with
+// gzip if compress is true.
+func newTar(t *testing.T, files map[string]string, compress bool) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	gz := gzip.NewWriter(&buf)
+	if compress {
+		out = gz
+	}
+
+	w := tar.NewWriter(out)
+	for _, name := range sortedKeys(files) {
+		hdr := &tar.Header{Name: name, Size: int64(len(files[name])), Mode: 0644, Typeflag: tar.TypeReg, ModTime: time.Unix(1e9, 0)}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); compress && err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// archiveTestFiles are the members of the archives of the tests.
+var archiveTestFiles = map[string]string{
+	"a.txt":       "A",
+	"sub/b.txt":   "BB",
+	"sub/c/d.bin": "stored",
+	"big.txt":     strings.Repeat("0123456789", 100000),
+	"../evil.txt": "E",
+}
+
+func TestArchiveMembers(t *testing.T) {
+	files := map[string]string{
+		"/bad.zip": "not a zip",
+		"/e.zip":   newZip(t, archiveTestFiles),
+		"/e.tar":   newTar(t, archiveTestFiles, false),
+		"/e.tgz":   newTar(t, archiveTestFiles, true),
+		"/n.zip":   newZip(t, map[string]string{"in.tar": newTar(t, map[string]string{"deep/x.txt": "nested"}, false)}),
+	}
+	m := newMemStatikFS(t, newMemBackend(files), StatikStaleTime)
+	ctx := context.Background()
+
+	for _, archive := range []string{"/e.zip", "/e.tar", "/e.tgz"} {
+		for name, want := range archiveTestFiles {
+			name = archive + "/" + memberName(name)
+			if got := readAll(t, m, name); got != want {
+				t.Errorf("%s = %d bytes, want %d", name, len(got), len(want))
+			}
+		}
+
+		info, err := m.Stat(ctx, archive+"/sub/c")
+		if err != nil || !info.IsDir() {
+			t.Errorf("Stat(%s/sub/c) = %v, %v", archive, info, err)
+		}
+		for _, name := range []string{archive + "/missing.txt", archive + "/sub/missing/"} {
+			if _, err := m.OpenFile(ctx, name, os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("OpenFile(%s) = %v, want fs.ErrNotExist", name, err)
+			}
+		}
+	}
+
+	if got := readAll(t, m, "/n.zip/in.tar/deep/x.txt"); got != "nested" {
+		t.Errorf("/n.zip/in.tar/deep/x.txt = %q", got)
+	}
+	if _, err := m.OpenFile(ctx, "/bad.zip/", os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile(/bad.zip/) = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestArchiveMemberMarking(t *testing.T) {
+	files := map[string]string{
+		// files whose urls look like the ones of members
+		"/a!zip/b.txt": "plain",
+		"/c!tgz/d.txt": "plain too",
+		// a member whose name looks like the url of a member
+		"/e.zip": newZip(t, map[string]string{"f!tar/g.txt": "member"}),
+	}
+	m := newMemStatikFS(t, newMemBackend(files), StatikStaleTime)
+
+	for name, want := range map[string]string{
+		"/a!zip/b.txt":       "plain",
+		"/c!tgz/d.txt":       "plain too",
+		"/e.zip/f!tar/g.txt": "member",
+	} {
+		if got := readAll(t, m, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	statik, err := m.cache.Get(context.Background(), "/e.zip/f!tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, found := statik.file("g.txt"); !found || !isMember(file) || file.archive != archiveZip {
+		t.Errorf("g.txt = %+v, want a member of a zip archive", file)
+	}
+	if statik, err = m.cache.Get(context.Background(), "/a!zip"); err != nil {
+		t.Fatal(err)
+	}
+	if file, found := statik.file("b.txt"); !found || isMember(file) {
+		t.Errorf("b.txt = %+v, want a file of the backend", file)
+	}
+}
+
+func TestArchiveMemberRange(t *testing.T) {
+	big := archiveTestFiles["big.txt"]
+	b := newMemBackend(map[string]string{
+		"/e.zip": newZip(t, archiveTestFiles),
+		"/e.tar": newTar(t, archiveTestFiles, false),
+		"/e.tgz": newTar(t, archiveTestFiles, true),
+	})
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	for _, archive := range []string{"/e.zip", "/e.tar", "/e.tgz"} {
+		info, err := m.Stat(ctx, archive+"/big.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, object, err := m.archives.Open(ctx, info.(StatikFileInfo), 500005, 10, Validators{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(body)
+		body.Close()
+		if err != nil || string(contents) != big[500005:500015] || object.Offset != 500005 || object.Size != int64(len(big)) {
+			t.Errorf("%s: read %q, %+v, %v", archive, contents, object, err)
+		}
+	}
+}
+
+func TestArchiveIndexReuse(t *testing.T) {
+	b := newMemBackend(map[string]string{
+		"/e.zip": newZip(t, archiveTestFiles),
+		"/e.tar": newTar(t, archiveTestFiles, false),
+		"/e.tgz": newTar(t, archiveTestFiles, true),
+	})
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	for _, archive := range []string{"/e.zip", "/e.tar", "/e.tgz"} {
+		// index the archive, and read the local header of the zip member
+		if got := readAll(t, m, archive+"/sub/b.txt"); got != "BB" {
+			t.Fatalf("%s/sub/b.txt = %q", archive, got)
+		}
+
+		_, before := b.calls()
+		info, err := m.Stat(ctx, archive+"/sub/b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if object, err := m.archives.Stat(ctx, info.(StatikFileInfo)); err != nil || object.Size != 2 {
+			t.Fatalf("%s: Stat = %+v, %v", archive, object, err)
+		}
+
+		// the content cache is bypassed
+		body, _, err := m.archives.Open(ctx, info.(StatikFileInfo), 0, -1, Validators{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(body)
+		body.Close()
+		if err != nil || string(contents) != "BB" {
+			t.Fatalf("%s/sub/b.txt = %q, %v", archive, contents, err)
+		}
+
+		if _, after := b.calls(); after-before != 1 {
+			t.Errorf("%s: %d reads of the archive to stat and read indexed members, want 1", archive, after-before)
+		}
+	}
+}
+
+func TestArchiveLargeMember(t *testing.T) {
+	big := archiveTestFiles["big.txt"]
+	b := newMemBackend(map[string]string{
+		"/e.zip": newZip(t, archiveTestFiles),
+		"/e.tar": newTar(t, archiveTestFiles, false),
+		"/e.tgz": newTar(t, archiveTestFiles, true),
+	})
+
+	cache := NewStatikCache(DefaultStatikCacheSize, StatikStaleTime)
+	t.Cleanup(cache.Close)
+	m, err := NewStatikFS("mem://", WithBackend(b), WithStatikCache(cache), WithContentCache(NewContentCache(1<<20, 1<<10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, archive := range []string{"/e.zip", "/e.tar", "/e.tgz"} {
+		// index the archive
+		if _, err := m.Stat(ctx, archive+"/big.txt"); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := m.OpenFile(ctx, archive+"/big.txt", os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.(*StreamFile); !ok {
+			t.Errorf("%s: OpenFile = %T, want *StreamFile", archive, f)
+		}
+
+		_, before := b.calls()
+		var contents bytes.Buffer
+		buf := make([]byte, 4096)
+		for {
+			n, err := f.Read(buf)
+			contents.Write(buf[:n])
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		f.Close()
+
+		if contents.String() != big {
+			t.Errorf("%s: read %d bytes, want %d", archive, contents.Len(), len(big))
+		}
+		if _, after := b.calls(); after-before > 2 {
+			t.Errorf("%s: %d reads of the archive to read a member, want at most 2", archive, after-before)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxIndexedEntries = 4 * maxArchiveEntries // maximum number of entries of the archive indexes cached by an archiveBackend
+
+// archiveEntry is an entry of the index of an archive.
+type archiveEntry struct {
+	name string // path of the entry relative to the root of the archive
+	dir  bool
+	size int64 // uncompressed size
+	time time.Time
+	etag string // ETag of the contents of the entry
+
+	offset int64     // offset of the contents in the tar stream, decompressed for archiveTarGz
+	file   *zip.File // entry of a zip archive
+}
+
+// archiveIndex is the parsed index of an archive, from which its members are
+// found and opened without reading the archive again.
+type archiveIndex struct {
+	key     string // key of the index in an archiveIndexCache
+	archive StatikFileInfo
+	format  archiveFormat
+	entries []archiveEntry
+	files   map[string]int // position in entries of the regular files, by name
+
+	// zip archives only: local headers of the members, read when they are
+	// first opened
+	lock    sync.Mutex       // held while reader is used
+	reader  *archiveReaderAt // reader of the zip archive
+	offsets map[string]int64 // offsets of the contents of the members read so far, by name
+}
+
+// file returns the entry of the regular file called name.
+func (idx *archiveIndex) file(name string) (archiveEntry, bool) {
+	i, found := idx.files[name]
+	if !found {
+		return archiveEntry{}, false
+	}
+	return idx.entries[i], true
+}
+
+// dataOffset returns the offset in the zip archive of the contents of entry,
+// reading its local header with ctx the first time.
+func (idx *archiveIndex) dataOffset(ctx context.Context, entry archiveEntry) (int64, error) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if offset, found := idx.offsets[entry.name]; found {
+		return offset, nil
+	}
+
+	idx.reader.ctx = ctx
+	offset, err := entry.file.DataOffset()
+	idx.reader.ctx = context.Background()
+	if err != nil {
+		return 0, err
+	}
+
+	idx.offsets[entry.name] = offset
+	return offset, nil
+}
+
+// archiveVersion returns the validators of the version of archive, a file of
+// a listing, from its metadata.
+func archiveVersion(archive StatikFileInfo) Validators {
+	hash := sha256.Sum256([]byte(archive.Url + "\x00" + archive.Time.String() + "\x00" + archive.SizeRaw))
+	return Validators{ETag: strconv.Quote(hex.EncodeToString(hash[:16]))}
+}
+
+// readIndex reads the index of archive: the central directory of zip archives,
+// read with range reads, or the headers of tar archives, read from the start.
+func (b *archiveBackend) readIndex(ctx context.Context, archive StatikFileInfo) (*archiveIndex, error) {
+	idx := &archiveIndex{
+		archive: archive,
+		format:  archiveFormatOf(archive.Name()),
+		files:   map[string]int{},
+	}
+
+	add := func(entry archiveEntry) {
+		if _, found := idx.files[entry.name]; !found && !entry.dir {
+			idx.files[entry.name] = len(idx.entries)
+		}
+		idx.entries = append(idx.entries, entry)
+	}
+
+	if idx.format == archiveZip {
+		info, err := b.Stat(ctx, archive)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size < 0 {
+			return nil, fmt.Errorf("unknown size of zip archive %s", archive.Url)
+		}
+
+		// the central directory is read ahead, the local headers exactly
+		idx.reader = &archiveReaderAt{ctx: ctx, backend: b, archive: archive, ahead: NewRangeFile(ctx, b, archive)}
+		r, err := zip.NewReader(idx.reader, info.Size)
+		idx.reader.ahead.Close()
+		idx.reader.ahead = nil
+		idx.reader.ctx = context.Background()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(r.File) > maxArchiveEntries {
+			return nil, fmt.Errorf("too many entries in archive: %d", len(r.File))
+		}
+		idx.offsets = make(map[string]int64)
+		for _, f := range r.File {
+			size := int64(f.UncompressedSize64)
+			add(archiveEntry{
+				name: memberName(f.Name),
+				dir:  strings.HasSuffix(f.Name, "/"),
+				size: size,
+				time: f.Modified,
+				etag: fmt.Sprintf(`"%08x-%x"`, f.CRC32, size),
+				file: f,
+			})
+		}
+		return idx, nil
+	}
+
+	body, err := b.openTar(ctx, archive, idx.format)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	for {
+		hdr, err := body.Next()
+		if err == io.EOF {
+			return idx, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir || isSparse(hdr) {
+			continue
+		}
+		if len(idx.entries) == maxArchiveEntries {
+			return nil, fmt.Errorf("too many entries in archive: more than %d", maxArchiveEntries)
+		}
+		add(archiveEntry{
+			name: memberName(hdr.Name),
+			dir:  hdr.Typeflag == tar.TypeDir,
+			size: hdr.Size,
+			time: hdr.ModTime,
+			etag: fmt.Sprintf(`"%x-%x"`, hdr.ModTime.UnixNano(), hdr.Size),
+			// the headers are read exactly, up to the contents
+			offset: body.stream.n,
+		})
+	}
+}
+
+// isSparse reports whether hdr is the header of a sparse file, whose contents
+// are not stored as they are.
+func isSparse(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveIndexCache is a cache of the indexes of the archives of an
+// archiveBackend, holding up to maxIndexedEntries entries, and evicting the
+// least recently used indexes first. The zero value is an empty cache.
+type archiveIndexCache struct {
+	lock    sync.Mutex
+	entries int                      // total number of entries of the cached indexes
+	items   map[string]*list.Element // cached indexes by key
+	lru     *list.List               // of *archiveIndex, most recently used first
+	flights flightGroup[*archiveIndex]
+}
+
+// get returns the cached index for key, marking it as recently used.
+func (c *archiveIndexCache) get(key string) (*archiveIndex, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return el.Value.(*archiveIndex), true
+}
+
+// add caches idx, evicting the least recently used indexes to make room for
+// it.
+func (c *archiveIndexCache) add(idx *archiveIndex) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	if _, found := c.items[idx.key]; found {
+		return
+	}
+
+	c.items[idx.key] = c.lru.PushFront(idx)
+	c.entries += len(idx.entries)
+
+	for c.entries > maxIndexedEntries && c.lru.Len() > 1 {
+		oldest := c.lru.Remove(c.lru.Back()).(*archiveIndex)
+		delete(c.items, oldest.key)
+		c.entries -= len(oldest.entries)
+	}
+}
+
+// index returns the index of archive, read once per version of the archive.
+func (b *archiveBackend) index(ctx context.Context, archive StatikFileInfo) (*archiveIndex, error) {
+	key := archive.Url + "\x00" + archiveVersion(archive).ETag
+	if idx, found := b.indexes.get(key); found {
+		return idx, nil
+	}
+
+	return b.indexes.flights.Do(ctx, key, func(ctx context.Context) (*archiveIndex, error) {
+		idx, err := b.readIndex(ctx, archive)
+		if err != nil {
+			return nil, err
+		}
+
+		idx.key = key
+		b.indexes.add(idx)
+		return idx, nil
+	})
+}
+
+// archiveReaderAt is an io.ReaderAt reading a zip archive of a Backend with
+// range reads, made with ctx.
+type archiveReaderAt struct {
+	ctx     context.Context
+	backend Backend
+	archive StatikFileInfo
+	ahead   *RangeFile // if not nil, reads ahead of the requested ranges
+}
+
+// ReadAt implements io.ReaderAt for archiveReaderAt.
+func (r *archiveReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if r.ahead != nil {
+		return r.ahead.ReadAt(p, off)
+	}
+
+	body, err := openRange(r.ctx, r.backend, r.archive, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// openRange opens the contents of file of backend from offset, up to length
+// bytes or until its end if length is negative, skipping the contents before
+// offset if the backend can't start there.
+func openRange(ctx context.Context, backend Backend, file StatikFileInfo, offset, length int64) (io.ReadCloser, error) {
+	body, info, err := backend.Open(ctx, file, offset, length, Validators{})
+	if err != nil {
+		return nil, err
+	}
+	if info.Offset >= offset {
+		return body, nil
+	}
+
+	if _, err = io.CopyN(io.Discard, body, offset-info.Offset); err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return body, nil
+	}
+	return readCloser{Reader: io.LimitReader(body, length), Closer: body}, nil
+}
@@ -0,0 +1,104 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+
+	"golang.org/x/net/webdav"
+)
+
+// ErrNotModified is returned by a Backend when a conditional operation finds
+// that the resource didn't change.
+var ErrNotModified = errors.New("not modified")
+
+// Backend is the storage a StatikFS serves its directory listings and files
+// from.
+//
+// Backends report missing resources with errors matching fs.ErrNotExist, and
+// denied ones with errors matching fs.ErrPermission. Errors caused by a remote
+// server should be *UpstreamError, so that they are answered with the matching
+// HTTP status.
+//
+// A Backend must be goroutine-safe.
+type Backend interface {
+	// List returns the listing of the directory at path, which is "/" or a
+	// slash-separated path starting with "/" (and not ending with "/"), along
+	// with its validators.
+	//
+	// If prev holds the validators of a previous listing and the directory
+	// didn't change since then, List fails with ErrNotModified.
+	List(ctx context.Context, path string, prev Validators) (Statik, Validators, error)
+
+	// Stat returns the size and validators of the file described by file, a
+	// file of a listing returned by List.
+	Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error)
+
+	// Open returns the contents of the file described by file, a file of a
+	// listing returned by List, starting at offset and up to length bytes, or
+	// until its end if length is negative. The caller must close the returned
+	// reader.
+	//
+	// Backends unable to read a range may return the contents from the start
+	// of the file: the returned ObjectInfo tells where the contents start. If
+	// offset is past the end of the file, the contents are empty.
+	//
+	// If prev holds the validators of a previous read of the file and the file
+	// didn't change since then, Open fails with ErrNotModified.
+	Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error)
+}
+
+// FileOpener is implemented by the Backends able to open their files as
+// webdav.File, which are served as they are instead of being buffered or
+// streamed with range reads by StatikFS.
+type FileOpener interface {
+	// OpenFile opens the file described by file, a file of a listing returned
+	// by List.
+	OpenFile(ctx context.Context, file StatikFileInfo) (webdav.File, error)
+}
+
+// NewBackend returns the Backend for base, chosen by its scheme: a
+// LocalBackend for file:// urls, a DirBackend for dir:// urls, an S3Backend
+// for s3://bucket/prefix urls, a GitBackend for git+file:// urls, and an
+// HTTPBackend otherwise.
+func NewBackend(base string) (Backend, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewLocalBackend(u.Path)
+	case "dir":
+		return NewDirBackend(u.Path)
+	case "s3":
+		return NewS3Backend(s3Config, u.Host, u.Path)
+	case "git+file":
+		return NewGitBackend(gitConfig, "file://"+u.Path)
+	default:
+		return NewHTTPBackend(base), nil
+	}
+}
+
+// Validators identify a version of a resource of a Backend, to check whether
+// it changed with a conditional operation. The zero Validators match no
+// version.
+type Validators struct {
+	ETag         string // opaque identifier of the version, as in the ETag HTTP header
+	LastModified string // modification time of the version, in the format of the Last-Modified HTTP header
+}
+
+// IsZero reports whether v holds no validator.
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// ObjectInfo describes the contents of a file returned by a Backend.
+type ObjectInfo struct {
+	Validators
+
+	Size   int64 // exact size of the whole file, -1 if unknown
+	Offset int64 // offset in the file of the returned contents
+}
@@ -0,0 +1,155 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"sync"
+)
+
+// memBackend is a Backend serving listings and files held in memory.
+type memBackend struct {
+	lock  sync.Mutex
+	dirs  map[string]Statik // listings by path
+	files map[string]string // contents of the files by url
+	err   error             // if not nil, returned by every call
+	gate  chan struct{}     // if not nil, calls wait for it to be closed
+	lists int               // number of calls of List
+	opens int               // number of calls of Open
+}
+
+// newMemBackend returns a memBackend serving files, contents by path.
+func newMemBackend(files map[string]string) *memBackend {
+	b := &memBackend{
+		dirs:  map[string]Statik{"/": {}},
+		files: make(map[string]string),
+	}
+
+	for path, contents := range files {
+		b.add(path, contents)
+	}
+	return b
+}
+
+// add adds a file at path, creating its parent directories.
+func (b *memBackend) add(path, contents string) {
+	url := "mem://" + path
+	b.files[url] = contents
+
+	dir := pathpkg.Dir(path)
+	statik := b.dirs[dir]
+	statik.Files = append(statik.Files, StatikFileInfo{
+		NameRaw: pathpkg.Base(path),
+		Url:     url,
+		Mime:    "text/plain",
+		SizeRaw: fmt.Sprintf("%d B", len(contents)),
+	})
+	b.dirs[dir] = statik
+
+	for ; dir != "/"; dir = pathpkg.Dir(dir) {
+		parent := pathpkg.Dir(dir)
+		statik := b.dirs[parent]
+		found := false
+		for _, d := range statik.Directories {
+			found = found || d.Name() == pathpkg.Base(dir)
+		}
+		if !found {
+			statik.Directories = append(statik.Directories, StatikDirInfo{NameRaw: pathpkg.Base(dir)})
+			b.dirs[parent] = statik
+		}
+	}
+}
+
+// setErr makes every call fail with err, or succeed again if err is nil.
+func (b *memBackend) setErr(err error) {
+	b.lock.Lock()
+	b.err = err
+	b.lock.Unlock()
+}
+
+// calls returns the number of calls of List and Open.
+func (b *memBackend) calls() (lists, opens int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.lists, b.opens
+}
+
+// enter waits for the gate, if any, and returns the error to fail with.
+func (b *memBackend) enter(ctx context.Context) error {
+	b.lock.Lock()
+	gate := b.gate
+	b.lock.Unlock()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.err
+}
+
+// List implements Backend for memBackend.
+func (b *memBackend) List(ctx context.Context, path string, _ Validators) (Statik, Validators, error) {
+	b.lock.Lock()
+	b.lists++
+	b.lock.Unlock()
+
+	if err := b.enter(ctx); err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	statik, found := b.dirs[path]
+	if !found {
+		return Statik{}, Validators{}, fs.ErrNotExist
+	}
+	return statik, Validators{}, nil
+}
+
+// Stat implements Backend for memBackend.
+func (b *memBackend) Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error) {
+	if err := b.enter(ctx); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	contents, found := b.files[file.Url]
+	if !found {
+		return ObjectInfo{}, fs.ErrNotExist
+	}
+	return ObjectInfo{Size: int64(len(contents))}, nil
+}
+
+// Open implements Backend for memBackend.
+func (b *memBackend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, _ Validators) (io.ReadCloser, ObjectInfo, error) {
+	b.lock.Lock()
+	b.opens++
+	b.lock.Unlock()
+
+	if err := b.enter(ctx); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	contents, found := b.files[file.Url]
+	if !found {
+		return nil, ObjectInfo{}, fs.ErrNotExist
+	}
+
+	size := int64(len(contents))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+
+	body := io.NopCloser(bytes.NewReader([]byte(contents[offset:end])))
+	return body, ObjectInfo{Size: size, Offset: offset}, nil
+}
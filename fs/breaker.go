@@ -0,0 +1,160 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrCircuitOpen is returned for the requests to an upstream host that is
+// failing, without contacting it.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// breakerState is the state of a circuitBreaker.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // requests go through
+	breakerOpen                         // requests fail fast
+	breakerHalfOpen                     // a probe request goes through, the others fail fast
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker tracks the failures of the requests to an upstream host.
+//
+// After httpConfig.BreakerThreshold consecutive failures the breaker opens,
+// and requests fail fast with ErrCircuitOpen. After httpConfig.BreakerCooldown
+// a single probe request is let through: the breaker closes if it succeeds,
+// and opens again otherwise.
+type circuitBreaker struct {
+	host string
+
+	lock     sync.Mutex
+	state    breakerState
+	failures int       // consecutive failures
+	openedAt time.Time // when the breaker last opened
+	probing  bool      // whether a probe request is in flight
+}
+
+var (
+	breakers     = make(map[string]*circuitBreaker) // circuit breakers by host
+	breakersLock sync.Mutex
+)
+
+// breakerFor returns the circuit breaker of the host of rawUrl.
+func breakerFor(rawUrl string) *circuitBreaker {
+	host := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Host
+	}
+
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	b, found := breakers[host]
+	if !found {
+		b = &circuitBreaker{host: host}
+		breakers[host] = b
+	}
+	return b
+}
+
+// allow reports whether a request can be made, and the state of the breaker.
+// If the request is allowed, its outcome must be reported with done.
+func (b *circuitBreaker) allow() (bool, breakerState) {
+	if httpConfig.BreakerThreshold <= 0 {
+		return true, breakerClosed
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < httpConfig.BreakerCooldown {
+			return false, b.state
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true, b.state
+	case breakerHalfOpen:
+		if b.probing {
+			return false, b.state
+		}
+		b.probing = true
+		return true, b.state
+	default:
+		return true, b.state
+	}
+}
+
+// done reports the outcome of a request made with ctx and allowed by allow,
+// which returned state for it. Requests abandoned by their client, because ctx
+// was cancelled or expired, don't count as failures of the host.
+func (b *circuitBreaker) done(ctx context.Context, state breakerState, resp *http.Response, err error) {
+	if httpConfig.BreakerThreshold <= 0 {
+		return
+	}
+
+	abandoned := ctx.Err() != nil || errors.Is(err, context.Canceled)
+	failed := err != nil || resp.StatusCode >= 500
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if state == breakerHalfOpen {
+		b.probing = false
+	}
+	if abandoned {
+		// nothing was learnt about the host
+		return
+	}
+	if !failed {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= httpConfig.BreakerThreshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// setState changes the state of the breaker, logging the transition. The lock
+// must be held.
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+
+	event := log.Info()
+	if state == breakerOpen {
+		event = log.Warn()
+	}
+	event.Str("host", b.host).
+		Stringer("from", b.state).
+		Stringer("to", state).
+		Int("failures", b.failures).
+		Msg("upstream circuit breaker state changed")
+
+	b.state = state
+}
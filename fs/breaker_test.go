@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withHTTPConfig configures the HTTP client with cfg for the duration of t.
+func withHTTPConfig(t *testing.T, cfg HTTPConfig) {
+	t.Helper()
+	prev := httpConfig
+	if err := ConfigureHTTP(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ConfigureHTTP(prev) })
+}
+
+func TestBreakerIgnoresAbandonedRequests(t *testing.T) {
+	cfg := DefaultHTTPConfig
+	cfg.Retries = 0
+	cfg.BreakerThreshold = 2
+	withHTTPConfig(t, cfg)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		resp, err := httpGet(ctx, srv.URL+"/slow", nil)
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+			t.Fatalf("request %d: expected a timeout", i)
+		}
+	}
+
+	resp, err := httpGet(context.Background(), srv.URL+"/ok", nil)
+	if err != nil {
+		t.Fatalf("healthy request after abandoned ones failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestBreakerOpensOnFailures(t *testing.T) {
+	cfg := DefaultHTTPConfig
+	cfg.Retries = 0
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = time.Hour
+	withHTTPConfig(t, cfg)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := httpGet(context.Background(), srv.URL, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+
+	_, err := httpGet(context.Background(), srv.URL, nil)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	cfg := DefaultHTTPConfig
+	cfg.Retries = 0
+	cfg.BreakerThreshold = 1
+	cfg.BreakerCooldown = 50 * time.Millisecond
+	withHTTPConfig(t, cfg)
+
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	get := func() error {
+		resp, err := httpGet(context.Background(), srv.URL, nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	get()
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen after a failure, got %v", err)
+	}
+
+	// a failed probe opens the breaker again
+	time.Sleep(cfg.BreakerCooldown)
+	if err := get(); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen after a failed probe, got %v", err)
+	}
+
+	// a successful probe closes it
+	healthy.Store(true)
+	time.Sleep(cfg.BreakerCooldown)
+	for i := 0; i < 3; i++ {
+		if err := get(); err != nil {
+			t.Fatalf("request %d after a successful probe: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package fs
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+const (
+	DefaultContentCacheSize   = 512 * 1024 * 1024 // default byte budget of a ContentCache
+	DefaultContentCacheObject = 8 * 1024 * 1024   // default maximum size of a file in a ContentCache
+)
+
+// ContentCache is a cache of file contents with a total byte budget, evicting
+// the least recently used files first. Files bigger than the per-object
+// maximum are never cached.
+//
+// A single ContentCache is meant to be shared by all the StatikFS of a
+// server. The ContentCache is goroutine-safe.
+type ContentCache struct {
+	maxBytes  int64 // total byte budget
+	maxObject int64 // maximum size of a single cached file
+
+	lock  sync.Mutex
+	used  int64                    // bytes currently cached
+	items map[string]*list.Element // cached files by key
+	lru   *list.List               // of *contentCacheEl, most recently used first
+}
+
+// contentCacheEl represents a cached file.
+type contentCacheEl struct {
+	key string
+	buf *bytes.Buffer
+}
+
+// NewContentCache returns an empty ContentCache holding up to maxBytes bytes,
+// and files of at most maxObject bytes each.
+func NewContentCache(maxBytes, maxObject int64) *ContentCache {
+	if maxObject > maxBytes {
+		maxObject = maxBytes
+	}
+
+	return &ContentCache{
+		maxBytes:  maxBytes,
+		maxObject: maxObject,
+		items:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// Get returns the cached contents for key, marking them as recently used.
+func (c *ContentCache) Get(key string) (*bytes.Buffer, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return el.Value.(*contentCacheEl).buf, true
+}
+
+// Contains reports whether key is cached, without marking it as recently used.
+func (c *ContentCache) Contains(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, found := c.items[key]
+	return found
+}
+
+// Cacheable reports whether a file of the given size can be cached.
+func (c *ContentCache) Cacheable(size int64) bool {
+	return size <= c.maxObject
+}
+
+// Add caches buf under key, evicting the least recently used files to make
+// room for it. It returns false if buf is too big to be cached.
+//
+// The cache takes ownership of buf: it must not be modified afterwards.
+func (c *ContentCache) Add(key string, buf *bytes.Buffer) bool {
+	size := int64(buf.Len())
+	if !c.Cacheable(size) {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, found := c.items[key]; found {
+		c.removeElement(el)
+	}
+
+	for c.used+size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+
+	c.items[key] = c.lru.PushFront(&contentCacheEl{key: key, buf: buf})
+	c.used += size
+	return true
+}
+
+// removeElement removes el from the cache. The lock must be held.
+func (c *ContentCache) removeElement(el *list.Element) {
+	item := c.lru.Remove(el).(*contentCacheEl)
+	delete(c.items, item.key)
+	c.used -= int64(item.buf.Len())
+}
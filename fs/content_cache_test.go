@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// buffer returns a buffer of n bytes.
+func buffer(n int) *bytes.Buffer {
+	return bytes.NewBufferString(strings.Repeat("x", n))
+}
+
+func TestContentCacheAdd(t *testing.T) {
+	c := NewContentCache(100, 50)
+
+	if !c.Add("a", buffer(10)) {
+		t.Fatal("a not cached")
+	}
+	if !c.Contains("a") || c.Contains("b") {
+		t.Errorf("Contains(a), Contains(b) = %t, %t", c.Contains("a"), c.Contains("b"))
+	}
+	if buf, found := c.Get("a"); !found || buf.Len() != 10 {
+		t.Errorf("Get(a) = %v, %t", buf, found)
+	}
+
+	// adding a key again replaces its contents
+	if !c.Add("a", buffer(20)) {
+		t.Fatal("a not cached again")
+	}
+	if buf, found := c.Get("a"); !found || buf.Len() != 20 || c.used != 20 {
+		t.Errorf("Get(a) = %d bytes, %t, %d bytes used", buf.Len(), found, c.used)
+	}
+
+	// files bigger than the per-object maximum are never cached
+	if c.Cacheable(51) || c.Add("big", buffer(51)) || c.Contains("big") {
+		t.Error("cached a file over the per-object maximum")
+	}
+	if !c.Cacheable(50) || !c.Add("max", buffer(50)) {
+		t.Error("didn't cache a file of the per-object maximum")
+	}
+}
+
+func TestContentCacheEviction(t *testing.T) {
+	c := NewContentCache(100, 50)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Add(key, buffer(25))
+	}
+
+	// Get marks a as recently used, Contains doesn't mark b
+	c.Get("a")
+	c.Contains("b")
+
+	// the byte budget is kept by evicting the least recently used files
+	c.Add("e", buffer(40))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "e": true} {
+		if c.Contains(key) != want {
+			t.Errorf("Contains(%s) = %t, want %t", key, !want, want)
+		}
+	}
+	if c.used != 90 || c.used > c.maxBytes {
+		t.Errorf("%d bytes used, want 90", c.used)
+	}
+}
+
+func TestContentCacheMaxObject(t *testing.T) {
+	// the per-object maximum is clamped to the byte budget
+	c := NewContentCache(10, 50)
+	if c.Cacheable(11) || c.Add("a", buffer(11)) {
+		t.Error("cached a file over the byte budget")
+	}
+	if !c.Add("b", buffer(10)) || !c.Add("c", buffer(10)) || c.Contains("b") {
+		t.Error("files of the byte budget not cached in turn")
+	}
+}
+
+func TestContentCacheShared(t *testing.T) {
+	c := NewContentCache(DefaultContentCacheSize, DefaultContentCacheObject)
+	b := newMemBackend(map[string]string{"/a.txt": "hello"})
+	var fss [2]*StatikFS
+	for i := range fss {
+		m, err := NewStatikFS("mem://", WithBackend(b), WithContentCache(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { m.Close() })
+		fss[i] = m
+	}
+	m1, m2 := fss[0], fss[1]
+
+	if got := readAll(t, m1, "/a.txt"); got != "hello" {
+		t.Errorf("/a.txt = %q, want %q", got, "hello")
+	}
+	if !c.Contains("mem:///a.txt") {
+		t.Error("/a.txt not cached")
+	}
+
+	// the second StatikFS finds the file in the shared cache
+	_, opens := b.calls()
+	if got := readAll(t, m2, "/a.txt"); got != "hello" {
+		t.Errorf("/a.txt = %q, want %q", got, "hello")
+	}
+	if _, after := b.calls(); after != opens {
+		t.Errorf("%d opens reading a cached file", after-opens)
+	}
+}
@@ -0,0 +1,182 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+const maxLinkFileSize = 64 * 1024 // maximum size of a .url file read as a link
+
+// DirBackend is a Backend serving a plain directory tree of the local
+// filesystem, without statik.json files: the listing of each directory is
+// synthesized from its entries.
+//
+// Hidden entries are not listed. The mime type of files comes from their
+// extension, or from their contents if the extension is unknown. Internet
+// shortcuts (.url files) are listed as links to their URL.
+//
+// Files are opened as by a LocalBackend, and the same rules about the root
+// directory apply.
+type DirBackend struct {
+	*LocalBackend
+}
+
+// NewDirBackend returns a new DirBackend for the directory tree in the root
+// directory. The directory doesn't have to exist yet.
+func NewDirBackend(root string) (*DirBackend, error) {
+	local, err := NewLocalBackend(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirBackend{LocalBackend: local}, nil
+}
+
+// List implements Backend for DirBackend. Listings are synthesized at every
+// call, so they have no validators.
+func (b *DirBackend) List(ctx context.Context, path string, prev Validators) (Statik, Validators, error) {
+	name, err := b.resolve(path)
+	if err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	stat, err := os.Stat(name)
+	if err != nil {
+		return Statik{}, Validators{}, err
+	} else if !stat.IsDir() {
+		return Statik{}, Validators{}, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	statik := Statik{StatikDirInfo: b.dirInfo(path, stat)}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		entryPath := pathpkg.Join(path, entry.Name())
+		entryName := filepath.Join(name, entry.Name())
+
+		var info fs.FileInfo
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// links leading outside of the root are not listed
+			if entryName, err = b.resolve(entryPath); err == nil {
+				info, err = os.Stat(entryName)
+			}
+		} else {
+			info, err = entry.Info()
+		}
+		if err != nil {
+			// the entry is gone or unreachable
+			continue
+		}
+
+		switch {
+		case info.IsDir():
+			statik.Directories = append(statik.Directories, b.dirInfo(entryPath, info))
+		case info.Mode().IsRegular():
+			statik.Files = append(statik.Files, b.fileInfo(entryPath, entryName, info))
+		}
+	}
+
+	return statik, Validators{}, nil
+}
+
+// dirInfo returns the StatikDirInfo of the directory at path, described by
+// stat.
+func (b *DirBackend) dirInfo(path string, stat fs.FileInfo) StatikDirInfo {
+	return StatikDirInfo{
+		Url:     b.url(path),
+		Time:    stat.ModTime(),
+		NameRaw: pathpkg.Base(path),
+		Path:    path,
+		SizeRaw: "0 B",
+	}
+}
+
+// fileInfo returns the StatikFileInfo of the file at path, stored at name in
+// the local filesystem and described by stat.
+func (b *DirBackend) fileInfo(path, name string, stat fs.FileInfo) StatikFileInfo {
+	file := StatikFileInfo{
+		NameRaw: pathpkg.Base(path),
+		Path:    path,
+		Url:     b.url(path),
+		Mime:    detectMime(name),
+		SizeRaw: fmt.Sprintf("%d B", stat.Size()),
+		Time:    stat.ModTime(),
+	}
+
+	ext := pathpkg.Ext(file.NameRaw)
+	if strings.EqualFold(ext, ".url") && stat.Size() <= maxLinkFileSize {
+		if target, found := readLinkFile(name); found {
+			file.NameRaw = strings.TrimSuffix(file.NameRaw, ext)
+			file.Url = target
+			file.Mime = "text/statik-link"
+			file.SizeRaw = fmt.Sprintf("%d B", len(target))
+		}
+	}
+
+	return file
+}
+
+// mimeByExtension returns the mime type of a file called name from its
+// extension, or application/octet-stream if the extension is unknown.
+func mimeByExtension(name string) string {
+	if mimeType := mime.TypeByExtension(pathpkg.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// detectMime returns the mime type of the file at name, from its extension if
+// known, or from its first bytes otherwise.
+func detectMime(name string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(head[:n])
+}
+
+// readLinkFile returns the target of the internet shortcut at name, from its
+// URL= line.
+func readLinkFile(name string) (string, bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if found && strings.EqualFold(strings.TrimSpace(key), "URL") && value != "" {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,117 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirBackendList(t *testing.T) {
+	tmp := t.TempDir()
+	root, outside := filepath.Join(tmp, "root"), filepath.Join(tmp, "outside")
+	for name, contents := range map[string]string{
+		"root/a.txt":      "hello",
+		"root/doc.pdf":    "not really a pdf",
+		"root/page":       "<!DOCTYPE html><html></html>",
+		"root/blob":       "\x00\x01\x02\x03",
+		"root/.hidden":    "hidden",
+		"root/site.url":   "[InternetShortcut]\r\nURL=https://example.com/x\r\n",
+		"root/broken.url": "[InternetShortcut]\r\n",
+		"root/sub/b.txt":  "world",
+		"outside/c.txt":   "secret",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(tmp, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"root/in":  filepath.Join(root, "sub"),
+		"root/out": outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(tmp, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := NewDirBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	statik, _, err := b.List(ctx, "/", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hidden entries and links leading outside of the root are not listed
+	var dirs []string
+	for _, dir := range statik.Directories {
+		dirs = append(dirs, dir.Name())
+	}
+	if got := strings.Join(dirs, ","); got != "in,sub" {
+		t.Errorf("directories = %s, want in,sub", got)
+	}
+
+	files := map[string]StatikFileInfo{}
+	for _, file := range statik.Files {
+		files[file.Name()] = file
+	}
+	for name, mime := range map[string]string{
+		"a.txt":   "text/plain; charset=utf-8",
+		"doc.pdf": "application/pdf",
+		"page":    "text/html; charset=utf-8",
+		"blob":    "application/octet-stream",
+		"site":    "text/statik-link",
+	} {
+		if file, found := files[name]; !found || file.Mime != mime {
+			t.Errorf("%s = %+v, %t, want mime %s", name, file, found, mime)
+		}
+		delete(files, name)
+	}
+	// the mime type of .url files depends on the system
+	if file, found := files["broken.url"]; !found || isLink(file) {
+		t.Errorf("broken.url = %+v, %t, want a file", file, found)
+	}
+	if delete(files, "broken.url"); len(files) != 0 {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	// internet shortcuts are links to their URL
+	for _, file := range statik.Files {
+		if file.Name() == "site" && (!isLink(file) || file.Url != "https://example.com/x") {
+			t.Errorf("site = %+v, want a link to https://example.com/x", file)
+		} else if file.Name() == "a.txt" && (file.Size() != 5 || file.Url != "file://"+filepath.ToSlash(root)+"/a.txt") {
+			t.Errorf("a.txt = %+v", file)
+		}
+	}
+
+	for _, path := range []string{"/a.txt", "/missing", "/out"} {
+		if _, _, err := b.List(ctx, path, Validators{}); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("List(%s) = %v, want fs.ErrNotExist", path, err)
+		}
+	}
+
+	// served by a StatikFS
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	for name, want := range map[string]string{"/a.txt": "hello", "/sub/b.txt": "world", "/in/b.txt": "world"} {
+		if got := readAll(t, m, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	dir, err := m.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := dir.Readdir(0)
+	if err != nil || !strings.Contains(names(infos), "site.desktop") {
+		t.Errorf("Readdir(/) = %s, %v, want the link as site.desktop", names(infos), err)
+	}
+}
@@ -0,0 +1,334 @@
+package fs
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultDiskCacheSize = 4 * 1024 * 1024 * 1024 // default byte budget of a DiskCache
+
+	diskCacheDataExt = ".data" // extension of the files holding cached contents
+	diskCacheMetaExt = ".json" // extension of the files holding cached metadata
+	diskCacheTmp     = ".tmp-" // prefix of the files being written
+)
+
+// DiskCache is a persistent cache of file contents, stored in a directory so
+// that it survives restarts. Every file is stored by the hash of its url,
+// together with a metadata file recording the upstream validators and the
+// statik time of the cached contents.
+//
+// Writes go to a temporary file that is renamed into place, so a crash never
+// leaves a partially written entry behind. The least recently used entries
+// are evicted when the cache grows beyond its byte budget.
+//
+// A single DiskCache is meant to be shared by all the StatikFS of a server.
+// The DiskCache is goroutine-safe.
+type DiskCache struct {
+	dir      string // directory holding the cached files
+	maxBytes int64  // total byte budget
+
+	lock  sync.Mutex
+	used  int64                    // bytes currently cached
+	items map[string]*list.Element // cached entries by hash
+	lru   *list.List               // of *diskCacheEl, most recently used first
+}
+
+// diskCacheMeta is the metadata stored alongside a cached file.
+type diskCacheMeta struct {
+	Url          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Time         time.Time `json:"time"` // StatikFileInfo.Time of the cached contents
+	Size         int64     `json:"size"`
+}
+
+// diskCacheEl represents an entry of the index of a DiskCache.
+type diskCacheEl struct {
+	hash string
+	meta diskCacheMeta
+}
+
+// NewDiskCache returns a DiskCache storing up to maxBytes bytes in dir,
+// creating the directory if needed. The entries already in dir are indexed,
+// and broken or partially written entries are removed.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	if err := c.scan(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// scan rebuilds the index from the contents of the cache directory, ordering
+// entries by the modification time of their data file.
+func (c *DiskCache) scan() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type scanned struct {
+		el    *diskCacheEl
+		mtime time.Time
+	}
+	var found []scanned
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if strings.HasPrefix(name, diskCacheTmp) {
+			// leftover of a crash during a write
+			c.remove(name)
+			continue
+		}
+
+		hash := strings.TrimSuffix(name, diskCacheMetaExt)
+		if hash == name {
+			if strings.HasSuffix(name, diskCacheDataExt) {
+				// data without metadata: remove it if the metadata is missing
+				metaName := strings.TrimSuffix(name, diskCacheDataExt) + diskCacheMetaExt
+				if _, err := os.Stat(filepath.Join(c.dir, metaName)); errors.Is(err, os.ErrNotExist) {
+					c.remove(name)
+				}
+			}
+			continue
+		}
+
+		meta, mtime, err := c.readMeta(hash)
+		if err != nil {
+			log.Warn().Err(err).Str("hash", hash).Msg("removing broken disk cache entry")
+			c.removeEntry(hash)
+			continue
+		}
+
+		found = append(found, scanned{&diskCacheEl{hash: hash, meta: meta}, mtime})
+	}
+
+	// most recently used first
+	sort.Slice(found, func(i, j int) bool { return found[i].mtime.After(found[j].mtime) })
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, s := range found {
+		c.items[s.el.hash] = c.lru.PushBack(s.el)
+		c.used += s.el.meta.Size
+	}
+	c.evict()
+
+	log.Info().Str("dir", c.dir).Int("entries", c.lru.Len()).Int64("bytes", c.used).Msg("disk cache loaded")
+	return nil
+}
+
+// readMeta reads the metadata of the entry with the given hash, checking that
+// its data file is complete.
+func (c *DiskCache) readMeta(hash string) (diskCacheMeta, time.Time, error) {
+	var meta diskCacheMeta
+
+	raw, err := os.ReadFile(filepath.Join(c.dir, hash+diskCacheMetaExt))
+	if err != nil {
+		return meta, time.Time{}, err
+	}
+	if err = json.Unmarshal(raw, &meta); err != nil {
+		return meta, time.Time{}, err
+	}
+
+	info, err := os.Stat(filepath.Join(c.dir, hash+diskCacheDataExt))
+	if err != nil {
+		return meta, time.Time{}, err
+	}
+	if info.Size() != meta.Size {
+		return meta, time.Time{}, errors.New("size mismatch")
+	}
+
+	return meta, info.ModTime(), nil
+}
+
+// Get returns the cached contents for url, together with their metadata. The
+// caller must check whether the contents are still fresh.
+func (c *DiskCache) Get(url string) (*bytes.Buffer, diskCacheMeta, bool) {
+	hash := diskCacheHash(url)
+
+	c.lock.Lock()
+	el, found := c.items[hash]
+	var meta diskCacheMeta
+	if found {
+		c.lru.MoveToFront(el)
+		meta = el.Value.(*diskCacheEl).meta
+	}
+	c.lock.Unlock()
+
+	if !found {
+		return nil, diskCacheMeta{}, false
+	}
+
+	dataPath := filepath.Join(c.dir, hash+diskCacheDataExt)
+	data, err := os.ReadFile(dataPath)
+	if err != nil || int64(len(data)) != meta.Size {
+		log.Warn().Err(err).Str("url", url).Msg("removing broken disk cache entry")
+		c.lock.Lock()
+		c.drop(hash)
+		c.lock.Unlock()
+		return nil, diskCacheMeta{}, false
+	}
+
+	// keep the recency across restarts
+	now := time.Now()
+	_ = os.Chtimes(dataPath, now, now)
+
+	return bytes.NewBuffer(data), meta, true
+}
+
+// Put stores buf as the contents of url, with the given metadata.
+func (c *DiskCache) Put(buf *bytes.Buffer, meta diskCacheMeta) error {
+	meta.Size = int64(buf.Len())
+	if meta.Size > c.maxBytes {
+		return nil
+	}
+
+	hash := diskCacheHash(meta.Url)
+
+	rawMeta, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// the data is written first: an entry is valid only once its metadata
+	// exists and matches the data
+	if err = c.writeFile(hash+diskCacheDataExt, buf.Bytes()); err != nil {
+		return err
+	}
+	if err = c.writeFile(hash+diskCacheMetaExt, rawMeta); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, found := c.items[hash]; found {
+		c.used -= el.Value.(*diskCacheEl).meta.Size
+		c.lru.Remove(el)
+	}
+	c.items[hash] = c.lru.PushFront(&diskCacheEl{hash: hash, meta: meta})
+	c.used += meta.Size
+	c.evict()
+
+	return nil
+}
+
+// UpdateMeta replaces the metadata of the cached contents of meta.Url, if
+// any, keeping the contents.
+func (c *DiskCache) UpdateMeta(meta diskCacheMeta) error {
+	hash := diskCacheHash(meta.Url)
+
+	// the lock is held while writing, so that the entry can't be evicted or
+	// replaced in the meantime, leaving the metadata without its contents
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, found := c.items[hash]
+	if !found {
+		return nil
+	}
+	meta.Size = el.Value.(*diskCacheEl).meta.Size
+
+	rawMeta, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err = c.writeFile(hash+diskCacheMetaExt, rawMeta); err != nil {
+		return err
+	}
+
+	el.Value.(*diskCacheEl).meta = meta
+	return nil
+}
+
+// writeFile atomically writes data to name in the cache directory.
+func (c *DiskCache) writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(c.dir, diskCacheTmp+"*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// evict removes the least recently used entries until the cache fits in its
+// budget. The lock must be held.
+func (c *DiskCache) evict() {
+	for c.used > c.maxBytes && c.lru.Len() > 0 {
+		c.drop(c.lru.Back().Value.(*diskCacheEl).hash)
+	}
+}
+
+// drop removes the entry with the given hash from the index and the disk. The
+// lock must be held.
+func (c *DiskCache) drop(hash string) {
+	if el, found := c.items[hash]; found {
+		c.used -= el.Value.(*diskCacheEl).meta.Size
+		c.lru.Remove(el)
+		delete(c.items, hash)
+	}
+	c.removeEntry(hash)
+}
+
+// removeEntry removes the files of the entry with the given hash.
+func (c *DiskCache) removeEntry(hash string) {
+	// metadata first, so that a crash leaves orphaned data, which is cleaned up
+	// by the next scan
+	c.remove(hash + diskCacheMetaExt)
+	c.remove(hash + diskCacheDataExt)
+}
+
+// remove removes the file name from the cache directory.
+func (c *DiskCache) remove(name string) {
+	err := os.Remove(filepath.Join(c.dir, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Str("file", name).Msg("failed to remove disk cache file")
+	}
+}
+
+// diskCacheHash returns the name under which the contents of url are stored.
+func diskCacheHash(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDiskCache returns a DiskCache of maxBytes bytes in dir.
+func newTestDiskCache(t *testing.T, dir string, maxBytes int64) *DiskCache {
+	t.Helper()
+
+	c, err := NewDiskCache(dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// put caches contents for url in c.
+func put(t *testing.T, c *DiskCache, url, contents string) {
+	t.Helper()
+
+	if err := c.Put(bytes.NewBufferString(contents), diskCacheMeta{Url: url, ETag: `"1"`}); err != nil {
+		t.Fatalf("Put(%s): %v", url, err)
+	}
+}
+
+// cached returns the cached contents for url in c, if any.
+func cached(c *DiskCache, url string) (string, bool) {
+	buf, _, found := c.Get(url)
+	if !found {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// entries returns the names of the files in dir.
+func entries(t *testing.T, dir string) map[string]bool {
+	t.Helper()
+
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool, len(list))
+	for _, entry := range list {
+		names[entry.Name()] = true
+	}
+	return names
+}
+
+func TestDiskCacheRescan(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestDiskCache(t, dir, 1024)
+	put(t, c, "mem:///a", "hello")
+	put(t, c, "mem:///b", "world")
+
+	// leftovers of crashes: a partial write, data without metadata, and an
+	// entry whose data doesn't match its metadata
+	orphan := diskCacheHash("mem:///orphan")
+	broken := diskCacheHash("mem:///broken")
+	for name, contents := range map[string]string{
+		diskCacheTmp + "123":           "partial",
+		orphan + diskCacheDataExt:      "orphan",
+		broken + diskCacheDataExt:      "short",
+		broken + diskCacheMetaExt:      `{"url":"mem:///broken","size":100}`,
+		"unrelated" + diskCacheMetaExt: "{",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c = newTestDiskCache(t, dir, 1024)
+	for url, want := range map[string]string{"mem:///a": "hello", "mem:///b": "world"} {
+		if got, found := cached(c, url); !found || got != want {
+			t.Errorf("%s = %q, %t after a restart, want %q", url, got, found, want)
+		}
+	}
+	if _, found := cached(c, "mem:///broken"); found {
+		t.Error("broken entry found after a restart")
+	}
+	if c.used != 10 || c.lru.Len() != 2 {
+		t.Errorf("%d entries of %d bytes after a restart, want 2 of 10", c.lru.Len(), c.used)
+	}
+
+	if got := entries(t, dir); len(got) != 4 {
+		t.Errorf("files after a restart = %v, want the 2 entries", got)
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestDiskCache(t, dir, 10)
+	put(t, c, "mem:///a", "aaaa")
+	put(t, c, "mem:///b", "bbbb")
+
+	// a is used more recently than b, which is evicted first
+	cached(c, "mem:///a")
+	put(t, c, "mem:///c", "cccc")
+	for url, want := range map[string]bool{"mem:///a": true, "mem:///b": false, "mem:///c": true} {
+		if _, found := cached(c, url); found != want {
+			t.Errorf("%s cached = %t, want %t", url, found, want)
+		}
+	}
+	if got := entries(t, dir); len(got) != 4 || got[diskCacheHash("mem:///b")+diskCacheDataExt] {
+		t.Errorf("files after evicting b = %v", got)
+	}
+
+	// files over the byte budget are never cached
+	put(t, c, "mem:///big", "0123456789a")
+	if _, found := cached(c, "mem:///big"); found || c.used != 8 {
+		t.Errorf("file over the byte budget cached, %d bytes used", c.used)
+	}
+
+	// the recency survives a restart, and the budget is kept by the scan
+	time.Sleep(10 * time.Millisecond)
+	cached(c, "mem:///a")
+	c = newTestDiskCache(t, dir, 4)
+	if _, found := cached(c, "mem:///a"); !found {
+		t.Error("most recently used entry evicted after a restart")
+	}
+	if _, found := cached(c, "mem:///c"); found || c.used != 4 {
+		t.Errorf("least recently used entry kept after a restart, %d bytes used", c.used)
+	}
+}
+
+func TestDiskCacheUpdateMeta(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestDiskCache(t, dir, 10)
+	put(t, c, "mem:///a", "hello")
+
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := c.UpdateMeta(diskCacheMeta{Url: "mem:///a", ETag: `"2"`, Time: modified}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*DiskCache{c, newTestDiskCache(t, dir, 10)} {
+		buf, meta, found := c.Get("mem:///a")
+		if !found || buf.String() != "hello" || meta.ETag != `"2"` || !meta.Time.Equal(modified) || meta.Size != 5 {
+			t.Errorf("after UpdateMeta = %v, %+v, %t", buf, meta, found)
+		}
+	}
+
+	// the metadata of missing or evicted entries isn't written
+	put(t, c, "mem:///b", "0123456789")
+	if err := c.UpdateMeta(diskCacheMeta{Url: "mem:///a", ETag: `"3"`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateMeta(diskCacheMeta{Url: "mem:///missing", ETag: `"3"`}); err != nil {
+		t.Fatal(err)
+	}
+	if got := entries(t, dir); len(got) != 2 {
+		t.Errorf("files after updating evicted entries = %v, want only b", got)
+	}
+}
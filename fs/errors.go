@@ -0,0 +1,148 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const maxBufferedSize = 64 * 1024 * 1024 // maximum size of an upstream response read in memory
+
+var (
+	ErrUpstreamNotFound    = errors.New("not found upstream")          // the upstream server doesn't have the resource
+	ErrUpstreamForbidden   = errors.New("forbidden by upstream")       // the upstream server denies access to the resource
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")        // the upstream server can't be reached or is failing
+	ErrUpstreamTooLarge    = errors.New("upstream response too large") // the upstream response is too large to be read in memory
+)
+
+// UpstreamError is an error caused by a request to the upstream server.
+//
+// It matches fs.ErrNotExist or fs.ErrPermission with errors.Is when the
+// resource doesn't exist or access is denied, so that it can be handled as a
+// filesystem error.
+type UpstreamError struct {
+	Url        string // url of the request
+	StatusCode int    // status code of the response, 0 if there was no response
+	Kind       error  // one of the ErrUpstream* errors
+	Err        error  // underlying error, if any
+}
+
+func (e *UpstreamError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Url, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error of e.
+func (e *UpstreamError) Unwrap() error { return e.Err }
+
+// Is makes e match its kind, and the fs errors corresponding to it.
+func (e *UpstreamError) Is(target error) bool {
+	switch target {
+	case e.Kind:
+		return true
+	case fs.ErrNotExist:
+		return e.Kind == ErrUpstreamNotFound
+	case fs.ErrPermission:
+		return e.Kind == ErrUpstreamForbidden
+	}
+	return false
+}
+
+// checkStatus returns an *UpstreamError if resp, the response to a request to
+// url, doesn't have a successful status. A 304 is an error too: it is only
+// expected in answer to conditional requests, which callers check for first.
+func checkStatus(url string, resp *http.Response) error {
+	var kind error
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		kind = ErrUpstreamNotFound
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		kind = ErrUpstreamForbidden
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		kind = ErrUpstreamTooLarge
+	default:
+		kind = ErrUpstreamUnavailable
+	}
+
+	return &UpstreamError{Url: url, StatusCode: resp.StatusCode, Kind: kind}
+}
+
+// HTTPStatus returns the HTTP status code to answer with when a request fails
+// because of err, or 0 if err is not caused by the upstream server.
+func HTTPStatus(err error) int {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return 0
+	}
+
+	var netErr net.Error
+	switch {
+	case upstreamErr.Kind == ErrUpstreamNotFound:
+		return http.StatusNotFound
+	case upstreamErr.Kind == ErrUpstreamForbidden:
+		return http.StatusForbidden
+	case upstreamErr.StatusCode == http.StatusServiceUnavailable,
+		errors.Is(upstreamErr.Err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case upstreamErr.StatusCode == http.StatusGatewayTimeout,
+		errors.Is(upstreamErr.Err, context.DeadlineExceeded),
+		errors.As(upstreamErr.Err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// errorRecorderKey is the context key of the errorRecorder of a request.
+type errorRecorderKey struct{}
+
+// errorRecorder holds the last upstream error met while handling a request.
+type errorRecorder struct {
+	lock sync.Mutex
+	err  error
+}
+
+// WithErrorRecorder returns a copy of ctx in which a StatikFS records the
+// upstream errors it meets, to be retrieved with RecordedError.
+func WithErrorRecorder(ctx context.Context) context.Context {
+	return context.WithValue(ctx, errorRecorderKey{}, &errorRecorder{})
+}
+
+// RecordedError returns the last upstream error recorded in ctx, if any.
+func RecordedError(ctx context.Context) error {
+	rec, ok := ctx.Value(errorRecorderKey{}).(*errorRecorder)
+	if !ok {
+		return nil
+	}
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.err
+}
+
+// recordError records err in ctx if it is an upstream error and ctx was
+// created by WithErrorRecorder. It returns err.
+func recordError(ctx context.Context, err error) error {
+	rec, ok := ctx.Value(errorRecorderKey{}).(*errorRecorder)
+	if !ok || HTTPStatus(err) == 0 {
+		return err
+	}
+
+	rec.lock.Lock()
+	rec.err = err
+	rec.lock.Unlock()
+	return err
+}
@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// flightGroup collapses concurrent calls with the same key into a single
+// call, whose result is shared by all the callers.
+type flightGroup[T any] struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall[T] // calls in flight by key
+}
+
+// flightCall represents a call in flight.
+type flightCall[T any] struct {
+	done    chan struct{}      // closed when the call returns
+	cancel  context.CancelFunc // cancels the context of the call
+	leader  trace.SpanContext  // span of the call
+	waiters int                // number of callers still waiting for the call
+	callers int                // number of callers of the call
+
+	val T
+	err error
+}
+
+// Do calls fn and returns its results, unless a call with the same key is
+// already in flight: in that case, Do waits for it and returns its results.
+//
+// The call doesn't use the context of its callers: it is cancelled only when
+// the contexts of all its callers are done. A caller whose context is done
+// returns immediately with the context error.
+//
+// Each call gets a span, and each coalesced caller a span linked to it.
+func (g *flightGroup[T]) Do(ctx context.Context, key string, fn func(context.Context) (T, error)) (T, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+
+	call, found := g.calls[key]
+	if found {
+		call.waiters++
+		call.callers++
+		g.lock.Unlock()
+
+		_, span := tr.Start(ctx, "flight.wait",
+			trace.WithLinks(trace.Link{SpanContext: call.leader}),
+			trace.WithAttributes(attribute.String("key", key)))
+		defer span.End()
+	} else {
+		call = g.start(ctx, key, fn)
+		g.lock.Unlock()
+	}
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody is interested in the result anymore
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.lock.Unlock()
+
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// start starts a call of fn for key in the background. The lock must be held.
+func (g *flightGroup[T]) start(ctx context.Context, key string, fn func(context.Context) (T, error)) *flightCall[T] {
+	// keep the trace of the first caller, but not its cancellation
+	callCtx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	callCtx, span := tr.Start(callCtx, "flight.do", trace.WithAttributes(attribute.String("key", key)))
+	callCtx, cancel := context.WithCancel(callCtx)
+
+	call := &flightCall[T]{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		leader:  span.SpanContext(),
+		waiters: 1,
+		callers: 1,
+	}
+	g.calls[key] = call
+
+	go func() {
+		defer span.End()
+
+		val, err := fn(callCtx)
+		cancel()
+
+		g.lock.Lock()
+		if g.calls[key] == call {
+			delete(g.calls, key)
+		}
+		span.SetAttributes(attribute.Int("callers", call.callers))
+		g.lock.Unlock()
+
+		call.val, call.err = val, err
+		close(call.done)
+	}()
+
+	return call
+}
@@ -8,9 +8,9 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
-	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -20,7 +20,7 @@ import (
 
 const (
 	StatikCachingTime = 5 * time.Minute // how long to cache statik.json files
-	fileCacheSize     = 100             // number of files to cache
+	StatikStaleTime   = time.Hour       // default for how long to serve expired statik.json files
 )
 
 var (
@@ -32,29 +32,105 @@ var (
 )
 
 // StatikFS represents a virtual filesystem that is backed by a statik.json files
-// in a remote server.
+// in a remote server, or by the listings of another Backend.
 type StatikFS struct {
-	baseUrl   string                            // base url of the remote server
-	cache     *statikCache                      // cache of statik.json files
-	openFiles *lru.Cache[string, *bytes.Buffer] // cache of open files (to avoid re-fetching them)
+	baseUrl   string          // base url of the remote server, also identifying the StatikFS in shared caches
+	backend   Backend         // source of the listings and files
+	archives  *archiveBackend // backend, browsing into its archives
+	statiks   *StatikCache    // shared cache of listings
+	ownCache  bool            // statiks was created by NewStatikFS, and is closed by Close
+	cache     *statikCache    // view of statiks for this StatikFS
+	openFiles *ContentCache   // cache of open files (to avoid re-fetching them)
+	diskCache *DiskCache      // persistent cache of fetched files, may be nil
+	links     LinkFormat      // format of the files served for links
+
+	fileFlights flightGroup[*bytes.Buffer] // file fetches in flight by url
+	largeFiles  sync.Map                   // urls of the files found larger than listed, and too large to be read in memory
+}
+
+// Option configures a StatikFS created by NewStatikFS.
+type Option func(*StatikFS)
+
+// WithContentCache makes the StatikFS cache file contents in c, which may be
+// shared with other StatikFS. By default, each StatikFS has its own
+// ContentCache of DefaultContentCacheSize bytes.
+func WithContentCache(c *ContentCache) Option {
+	return func(m *StatikFS) { m.openFiles = c }
+}
+
+// WithDiskCache makes the StatikFS store fetched files in c, and look them up
+// there before fetching them from the remote server.
+func WithDiskCache(c *DiskCache) Option {
+	return func(m *StatikFS) { m.diskCache = c }
+}
+
+// WithStatikCache makes the StatikFS cache statik.json files in c, which may be
+// shared with other StatikFS. By default, each StatikFS has its own
+// StatikCache of DefaultStatikCacheSize bytes.
+func WithStatikCache(c *StatikCache) Option {
+	return func(m *StatikFS) { m.statiks = c }
+}
+
+// WithBackend makes the StatikFS serve the listings and files of b, instead of
+// those of the Backend returned by NewBackend for the base url.
+func WithBackend(b Backend) Option {
+	return func(m *StatikFS) { m.backend = b }
+}
+
+// WithLinkFormat makes the StatikFS serve links as files in format f. By
+// default, links are served as .desktop files.
+func WithLinkFormat(f LinkFormat) Option {
+	return func(m *StatikFS) { m.links = f }
 }
 
 // NewStatikFS returns a new StatikFS that is backed by a statik.json file in the
-// remote server at base url.
+// remote server at base url, or in the local directory of a file:// base url.
 //
 // The returned StatikFS is read-only. The returned StatikFS is goroutine-safe.
-func NewStatikFS(base string) (*StatikFS, error) {
-	fileCache, err := lru.New[string, *bytes.Buffer](fileCacheSize)
-	if err != nil {
-		return nil, err
+func NewStatikFS(base string, opts ...Option) (*StatikFS, error) {
+	m := &StatikFS{
+		baseUrl: base,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.backend == nil {
+		backend, err := NewBackend(base)
+		if err != nil {
+			return nil, err
+		}
+		m.backend = backend
+	}
+
+	if m.statiks == nil {
+		m.statiks = NewStatikCache(DefaultStatikCacheSize, StatikStaleTime)
+		m.ownCache = true
 	}
-	sCache := newStatikCache(base)
+	m.archives = &archiveBackend{Backend: m.backend}
+	m.cache = newStatikCache(m.statiks, base, m.archives)
+	m.archives.cache = m.cache
 
-	return &StatikFS{
-		openFiles: fileCache,
-		baseUrl:   base,
-		cache:     sCache,
-	}, nil
+	if m.openFiles == nil {
+		m.openFiles = NewContentCache(DefaultContentCacheSize, DefaultContentCacheObject)
+	}
+
+	if m.links == nil {
+		m.links = DesktopLinkFormat
+	}
+
+	return m, nil
+}
+
+// Close releases the resources of the StatikFS: the background sweeping of
+// its StatikCache, unless it was given by WithStatikCache. The StatikFS must
+// not be used afterwards.
+func (m *StatikFS) Close() error {
+	if m.ownCache {
+		m.statiks.Close()
+	}
+	return nil
 }
 
 // Mkdir implements webdav.FileSystem for StatikFS.
@@ -90,60 +166,95 @@ func (m *StatikFS) OpenFile(
 
 	statikPath := path.Dir(name)
 
-	ctx, statikSpan := tr.Start(ctx, "GetStatik")
-	statik, err := m.cache.Get(ctx, statikPath)
+	statikCtx, statikSpan := tr.Start(ctx, "GetStatik")
+	statik, err := m.cache.Get(statikCtx, statikPath)
 	if err != nil {
 		statikSpan.RecordError(err)
-		statikSpan.SetStatus(codes.Error, "requested statik.json for a path that doesn't exist")
-		return nil, fs.ErrNotExist
+		statikSpan.SetStatus(codes.Error, "failed to get statik.json for the path")
+		statikSpan.End()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, recordError(ctx, err)
 	}
 	statikSpan.End()
 
 	if strings.HasSuffix(name, "/") {
 		// we're opening a dir
-		return statik, nil
+		return newStatikDir(statik, m.linkFormat(ctx)), nil
 	}
 
 	name = path.Base(name)
 	name = strings.TrimPrefix(name, "/")
 
 	// we're opening a file
-	for _, file := range statik.Files {
-		if file.Name() == name {
-			span.AddEvent("file found")
-			return m.getFile(file), nil
-		}
+	if file, found := lookupFile(m.linkFormat(ctx), statik, name); found {
+		span.AddEvent("file found")
+		return m.getFile(ctx, file)
 	}
 
 	// we're opening a dir (??)
-	for _, dir := range statik.Directories {
-		if dir.Name() == name {
-			span.AddEvent("dir found")
-			redir := statikPath + "/" + name + "/"
-			return m.OpenFile(ctx, redir, flag, perm)
-		}
+	if _, found := statik.dir(name); found {
+		span.AddEvent("dir found")
+		redir := statikPath + "/" + name + "/"
+		return m.OpenFile(ctx, redir, flag, perm)
 	}
 
 	return nil, fs.ErrNotExist
 }
 
-func (m *StatikFS) getFile(file StatikFileInfo) webdav.File {
+func (m *StatikFS) getFile(ctx context.Context, file StatikFileInfo) (webdav.File, error) {
 
-	if file.Mime == "text/statik-link" {
-		return NewLinkFile(file)
+	if isLink(file) {
+		return NewLinkFile(m.linkFormat(ctx), file), nil
+	}
+
+	// members of archives are not files of the backend
+	member := isMember(file)
+	if opener, ok := m.backend.(FileOpener); ok && !member {
+		// the backend serves its files better than we could
+		f, err := opener.OpenFile(ctx, file)
+		if err != nil {
+			return nil, recordError(ctx, err)
+		}
+		return f, nil
+	}
+
+	// the size of the listing may be missing or wrong: files of unknown
+	// size are streamed, and files found too large to be read in memory
+	// are streamed from then on
+	size, err := parseSize(file.SizeRaw)
+	_, large := m.largeFiles.Load(file.Url)
+	if !m.openFiles.Contains(file.Url) && (err != nil || large || !m.openFiles.Cacheable(size)) {
+		return m.streamFile(ctx, file, member), nil
 	}
 
 	populate := m.createFilePopulate(file)
-	return NewLazyMemFile(file, populate)
+	f := NewLazyMemFile(ctx, file, populate)
+	f.tooLarge = func() webdav.File {
+		log.Debug().Str("url", file.Url).Msg("file larger than listed, streaming it")
+		m.largeFiles.Store(file.Url, struct{}{})
+		return m.streamFile(ctx, file, member)
+	}
+	return f, nil
 }
 
-func (m *StatikFS) createFilePopulate(file StatikFileInfo) func() (*bytes.Buffer, error) {
-	return func() (*bytes.Buffer, error) {
-
-		if file.Mime == "text/statik-link" {
-			return bytes.NewBufferString(file.Url), nil
-		}
+// streamFile returns file streamed instead of being buffered in memory: in a
+// single stream for the members of archives, since they may only be read from
+// their start, and with range reads for the other files.
+func (m *StatikFS) streamFile(ctx context.Context, file StatikFileInfo, member bool) webdav.File {
+	if member {
+		return NewStreamFile(ctx, m.archives, file)
+	}
+	return NewRangeFile(ctx, m.archives, file)
+}
 
+// createFilePopulate returns the function populating a LazyMemFile with the
+// contents of file, which is not a link: links are served as LinkFiles by
+// getFile. Upstream errors are recorded in the context of the populating
+// request.
+func (m *StatikFS) createFilePopulate(file StatikFileInfo) func(context.Context) (*bytes.Buffer, error) {
+	return func(ctx context.Context) (*bytes.Buffer, error) {
 		log.Debug().Str("url", file.Url).Msg("opening file")
 
 		buf, found := m.openFiles.Get(file.Url)
@@ -155,34 +266,85 @@ func (m *StatikFS) createFilePopulate(file StatikFileInfo) func() (*bytes.Buffer
 
 		// cache miss
 		log.Debug().Str("url", file.Url).Msg("cache miss")
-		buf, err := fetchBytes(file)
-		if err != nil {
-			return nil, err
+		buf, err := m.fileFlights.Do(ctx, file.Url, func(ctx context.Context) (*bytes.Buffer, error) {
+			buf, err := m.fetchFile(ctx, file)
+			if err != nil {
+				return nil, err
+			}
+			m.openFiles.Add(file.Url, buf) // populate cache
+
+			return buf, nil
+		})
+		return buf, recordError(ctx, err)
+	}
+}
+
+// fetchFile returns the contents of file from the disk cache, if enabled and
+// fresh, or from the backend otherwise.
+func (m *StatikFS) fetchFile(ctx context.Context, file StatikFileInfo) (*bytes.Buffer, error) {
+	if m.diskCache == nil {
+		buf, _, err := m.readFile(ctx, file, Validators{})
+		return buf, err
+	}
+
+	cached, meta, found := m.diskCache.Get(file.Url)
+	if found && meta.Time.Equal(file.Time) {
+		log.Debug().Str("url", file.Url).Msg("disk cache hit")
+		return cached, nil
+	}
+
+	// the file is missing or changed according to statik: revalidate it
+	var prev Validators
+	if found {
+		prev = Validators{ETag: meta.ETag, LastModified: meta.LastModified}
+	}
+
+	buf, validators, err := m.readFile(ctx, file, prev)
+	if errors.Is(err, ErrNotModified) && found {
+		log.Debug().Str("url", file.Url).Msg("disk cache revalidated")
+		meta.Time = file.Time
+		if err = m.diskCache.UpdateMeta(meta); err != nil {
+			log.Error().Err(err).Str("url", file.Url).Msg("failed to update disk cache")
 		}
-		m.openFiles.Add(file.Url, buf) // populate cache
+		return cached, nil
+	} else if err != nil {
+		return nil, err
+	}
 
-		return buf, nil
+	err = m.diskCache.Put(buf, diskCacheMeta{
+		Url:          file.Url,
+		ETag:         validators.ETag,
+		LastModified: validators.LastModified,
+		Time:         file.Time,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("url", file.Url).Msg("failed to populate disk cache")
 	}
+
+	return buf, nil
 }
 
-func fetchBytes(i StatikFileInfo) (*bytes.Buffer, error) {
-	resp, err := httpGet(context.Background(), i.Url)
+// readFile reads the whole contents of file from the backend, conditionally on
+// prev (which may be zero). It returns ErrNotModified if file didn't change
+// since the version identified by prev.
+func (m *StatikFS) readFile(ctx context.Context, file StatikFileInfo, prev Validators) (*bytes.Buffer, Validators, error) {
+	body, info, err := m.archives.Open(ctx, file, 0, -1, prev)
 	if err != nil {
-		return nil, err
+		return nil, Validators{}, err
 	}
+	defer body.Close()
 
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(resp.Body)
+	buf, err := readBody(file.Url, body, info.Size)
 	if err != nil {
-		return nil, err
+		return nil, Validators{}, err
 	}
 
-	err = resp.Body.Close()
+	err = body.Close()
 	if err != nil {
-		return nil, err
+		return nil, Validators{}, err
 	}
 
-	return &buf, nil
+	return buf, info.Validators, nil
 }
 
 // Stat implements webdav.FileSystem for StatikFS.
@@ -190,8 +352,10 @@ func (m *StatikFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	statikPath := path.Dir(name)
 
 	statik, err := m.cache.Get(ctx, statikPath)
-	if err != nil {
-		return nil, err
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, recordError(ctx, err)
 	}
 
 	if strings.HasSuffix(name, "/") {
@@ -203,17 +367,55 @@ func (m *StatikFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	name = strings.TrimPrefix(name, "/")
 
 	// we're opening a file
+	links := m.linkFormat(ctx)
+	if file, found := lookupFile(links, statik, name); found {
+		return virtualLink(links, file), nil
+	}
+
+	if dir, found := statik.dir(name); found {
+		return dir, nil
+	}
+
+	return nil, fs.ErrNotExist
+}
+
+// Walk calls fn for each file in the directory at root and in its
+// subdirectories, with the path of the file. As in the listings of the
+// directories, links are passed as the files served for them, unless hidden by
+// a file with the same served name. The listings are taken from the cache of
+// the StatikFS, and directories are walked depth first, files first.
+//
+// The directories of the tags of git repositories, holding other versions of
+// the whole tree, are only walked if root is inside one of them.
+//
+// If fn returns an error, the walk stops and Walk returns it.
+func (m *StatikFS) Walk(ctx context.Context, root string, fn func(name string, file StatikFileInfo) error) error {
+	root = path.Clean("/" + root)
+
+	statik, err := m.cache.Get(ctx, root)
+	if err != nil {
+		return recordError(ctx, err)
+	}
+
+	links := m.linkFormat(ctx)
 	for _, file := range statik.Files {
-		if file.Name() == name {
-			return file, nil
+		if hiddenLink(links, statik, file) {
+			continue
+		}
+		file = virtualLink(links, file)
+		if err = fn(path.Join(root, file.Name()), file); err != nil {
+			return err
 		}
 	}
 
 	for _, dir := range statik.Directories {
-		if dir.Name() == name {
-			return dir, nil
+		if dir.tag {
+			continue
+		}
+		if err = m.Walk(ctx, path.Join(root, dir.Name()), fn); err != nil {
+			return err
 		}
 	}
 
-	return nil, fs.ErrNotExist
+	return nil
 }
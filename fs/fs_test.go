@@ -0,0 +1,406 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMemStatikFS returns a StatikFS serving b, with its own StatikCache
+// serving expired listings for up to staleTime.
+func newMemStatikFS(t *testing.T, b Backend, staleTime time.Duration) *StatikFS {
+	t.Helper()
+
+	cache := NewStatikCache(DefaultStatikCacheSize, staleTime)
+	t.Cleanup(cache.Close)
+
+	m, err := NewStatikFS("mem://", WithBackend(b), WithStatikCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// expire makes the cached listing of the directory at path expire.
+func expire(m *StatikFS, path string) {
+	m.statiks.lock.Lock()
+	defer m.statiks.lock.Unlock()
+
+	item := m.statiks.items[m.baseUrl+path]
+	el := *item.Value.(*statikCacheEl)
+	el.exp = time.Now().Add(-time.Second)
+	item.Value = &el
+}
+
+// waitCallers waits until n callers are waiting for the call of g for key. It
+// can be called from any goroutine.
+func waitCallers[T any](t *testing.T, g *flightGroup[T], key string, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.lock.Lock()
+		call, found := g.calls[key]
+		done := found && call.callers == n
+		g.lock.Unlock()
+
+		if done {
+			return
+		}
+	}
+	t.Errorf("%d callers never waited for %s", n, key)
+}
+
+// readAll returns the contents of the file of m at name.
+func readAll(t *testing.T, m *StatikFS, name string) string {
+	t.Helper()
+
+	f, err := m.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%s): %v", name, err)
+	}
+	defer f.Close()
+
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return string(contents)
+}
+
+func TestOpenFileAndStat(t *testing.T) {
+	b := newMemBackend(map[string]string{
+		"/a.txt":     "hello",
+		"/sub/b.txt": "world",
+	})
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	if got := readAll(t, m, "/a.txt"); got != "hello" {
+		t.Errorf("/a.txt = %q, want %q", got, "hello")
+	}
+	if got := readAll(t, m, "/sub/b.txt"); got != "world" {
+		t.Errorf("/sub/b.txt = %q, want %q", got, "world")
+	}
+
+	info, err := m.Stat(ctx, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "a.txt" || info.Size() != 5 || info.IsDir() {
+		t.Errorf("Stat(/a.txt) = %s, %d bytes, dir %v", info.Name(), info.Size(), info.IsDir())
+	}
+
+	info, err = m.Stat(ctx, "/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "sub" || !info.IsDir() {
+		t.Errorf("Stat(/sub) = %s, dir %v", info.Name(), info.IsDir())
+	}
+
+	for _, name := range []string{"/sub", "/sub/"} {
+		dir, err := m.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatalf("OpenFile(%s): %v", name, err)
+		}
+		entries, err := dir.Readdir(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "b.txt" {
+			t.Errorf("Readdir(%s) = %v", name, entries)
+		}
+	}
+
+	for _, name := range []string{"/missing.txt", "/missing/a.txt", "/sub/a.txt"} {
+		if _, err := m.OpenFile(ctx, name, os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("OpenFile(%s) = %v, want fs.ErrNotExist", name, err)
+		}
+		if _, err := m.Stat(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%s) = %v, want fs.ErrNotExist", name, err)
+		}
+	}
+
+	if _, err := m.OpenFile(ctx, "/a.txt", os.O_RDWR, 0); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("OpenFile(/a.txt, O_RDWR) = %v, want fs.ErrPermission", err)
+	}
+}
+
+func TestOpenFileMisstatedSize(t *testing.T) {
+	large := strings.Repeat("0123456789", maxBufferedSize/10+1)
+	b := &rangeBackend{memBackend: newMemBackend(map[string]string{
+		"/unknown.txt":     "hello",
+		"/understated.txt": large,
+	})}
+	root := b.dirs["/"]
+	for i, file := range root.Files {
+		switch file.Name() {
+		case "unknown.txt":
+			root.Files[i].SizeRaw = ""
+		case "understated.txt":
+			root.Files[i].SizeRaw = "1 B"
+		}
+	}
+	b.dirs["/"] = root
+	m := newMemStatikFS(t, b, StatikStaleTime)
+
+	// files of unknown size are streamed with range reads
+	if got := readAll(t, m, "/unknown.txt"); got != "hello" {
+		t.Errorf("/unknown.txt = %q, want %q", got, "hello")
+	}
+	if len(b.ranges) == 0 || b.ranges[0] != fmt.Sprintf("0+%d", rangeMinWindow) {
+		t.Errorf("/unknown.txt read with ranges %q, want range reads", b.ranges)
+	}
+
+	// files larger than listed are streamed once found too large to be read
+	// in memory, and from the start afterwards
+	for i := 0; i < 2; i++ {
+		b.ranges = nil
+		if got := readAll(t, m, "/understated.txt"); got != large {
+			t.Fatalf("/understated.txt = %d bytes, want %d", len(got), len(large))
+		}
+		if i == 0 && (len(b.ranges) < 2 || b.ranges[0] != "0+-1") {
+			t.Errorf("/understated.txt read with ranges %q, want the whole file and then ranges", b.ranges)
+		} else if i == 1 && (len(b.ranges) == 0 || b.ranges[0] == "0+-1") {
+			t.Errorf("/understated.txt read again with ranges %q, want only ranges", b.ranges)
+		}
+	}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		err    error
+		status int // status of the recorded error, 0 if none
+	}{
+		{"not found", &UpstreamError{Url: "mem:///", StatusCode: http.StatusNotFound, Kind: ErrUpstreamNotFound}, 0},
+		{"forbidden", &UpstreamError{Url: "mem:///", StatusCode: http.StatusForbidden, Kind: ErrUpstreamForbidden}, http.StatusForbidden},
+		{"server error", &UpstreamError{Url: "mem:///", StatusCode: http.StatusInternalServerError, Kind: ErrUpstreamUnavailable}, http.StatusBadGateway},
+		{"unavailable", &UpstreamError{Url: "mem:///", StatusCode: http.StatusServiceUnavailable, Kind: ErrUpstreamUnavailable}, http.StatusServiceUnavailable},
+		{"circuit open", &UpstreamError{Url: "mem:///", Kind: ErrUpstreamUnavailable, Err: ErrCircuitOpen}, http.StatusServiceUnavailable},
+		{"gateway timeout", &UpstreamError{Url: "mem:///", StatusCode: http.StatusGatewayTimeout, Kind: ErrUpstreamUnavailable}, http.StatusGatewayTimeout},
+		{"timeout", &UpstreamError{Url: "mem:///", Kind: ErrUpstreamUnavailable, Err: context.DeadlineExceeded}, http.StatusGatewayTimeout},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newMemBackend(map[string]string{"/a.txt": "hello"})
+			b.setErr(test.err)
+			m := newMemStatikFS(t, b, StatikStaleTime)
+
+			ctx := WithErrorRecorder(context.Background())
+			_, err := m.OpenFile(ctx, "/a.txt", os.O_RDONLY, 0)
+			if test.status == 0 && !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("OpenFile = %v, want fs.ErrNotExist", err)
+			} else if test.status != 0 && !errors.Is(err, test.err) {
+				t.Errorf("OpenFile = %v, want %v", err, test.err)
+			}
+
+			if status := HTTPStatus(RecordedError(ctx)); status != test.status {
+				t.Errorf("recorded status = %d, want %d", status, test.status)
+			}
+		})
+	}
+}
+
+func TestFileErrorStatus(t *testing.T) {
+	b := newMemBackend(map[string]string{"/a.txt": "hello"})
+	m := newMemStatikFS(t, b, StatikStaleTime)
+
+	// the listing is fetched, the file isn't
+	ctx := WithErrorRecorder(context.Background())
+	f, err := m.OpenFile(ctx, "/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.setErr(&UpstreamError{Url: "mem:///a.txt", StatusCode: http.StatusServiceUnavailable, Kind: ErrUpstreamUnavailable})
+	if _, err = io.ReadAll(f); err == nil {
+		t.Fatal("read succeeded, want an error")
+	}
+	if status := HTTPStatus(RecordedError(ctx)); status != http.StatusServiceUnavailable {
+		t.Errorf("recorded status = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	unavailable := &UpstreamError{Url: "mem:///", StatusCode: http.StatusServiceUnavailable, Kind: ErrUpstreamUnavailable}
+	circuitOpen := &UpstreamError{Url: "mem:///", Kind: ErrUpstreamUnavailable, Err: ErrCircuitOpen}
+
+	for _, test := range []struct {
+		name      string
+		staleTime time.Duration
+		err       error
+		stale     bool // the stale listing is served
+	}{
+		{"within grace", time.Hour, unavailable, true},
+		{"past grace", 0, unavailable, false},
+		{"circuit open past grace", 0, circuitOpen, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newMemBackend(map[string]string{"/a.txt": "hello"})
+			m := newMemStatikFS(t, b, test.staleTime)
+			ctx := context.Background()
+
+			if _, err := m.Stat(ctx, "/a.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			expire(m, "/")
+			b.setErr(test.err)
+
+			// the second time, the failed background refresh is over
+			for i := 0; i < 2; i++ {
+				_, err := m.Stat(ctx, "/a.txt")
+				if test.stale && err != nil {
+					t.Fatalf("Stat = %v, want the stale listing", err)
+				} else if !test.stale && !errors.Is(err, test.err) {
+					t.Fatalf("Stat = %v, want %v", err, test.err)
+				}
+
+				m.statiks.lock.Lock()
+				for len(m.statiks.refreshing) > 0 {
+					m.statiks.lock.Unlock()
+					time.Sleep(time.Millisecond)
+					m.statiks.lock.Lock()
+				}
+				m.statiks.lock.Unlock()
+			}
+
+			b.setErr(nil)
+			if _, err := m.Stat(ctx, "/a.txt"); err != nil {
+				t.Fatalf("Stat after recovery = %v", err)
+			}
+		})
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	const callers = 10
+
+	b := newMemBackend(map[string]string{"/a.txt": "hello"})
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	run := func(fn func()) {
+		var wg sync.WaitGroup
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fn()
+			}()
+		}
+		wg.Wait()
+	}
+
+	hold := func() chan struct{} {
+		gate := make(chan struct{})
+		b.lock.Lock()
+		b.gate = gate
+		b.lock.Unlock()
+		return gate
+	}
+
+	// listings
+	gate := hold()
+	go func() {
+		waitCallers(t, &m.statiks.flights, m.baseUrl+"/", callers)
+		close(gate)
+	}()
+	run(func() {
+		if _, err := m.Stat(ctx, "/a.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// files
+	gate = hold()
+	go func() {
+		waitCallers(t, &m.fileFlights, "mem:///a.txt", callers)
+		close(gate)
+	}()
+	run(func() {
+		f, err := m.OpenFile(ctx, "/a.txt", os.O_RDONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		contents, err := io.ReadAll(f)
+		if err != nil || string(contents) != "hello" {
+			t.Errorf("read %q, %v", contents, err)
+		}
+	})
+
+	if lists, opens := b.calls(); lists != 1 || opens != 1 {
+		t.Errorf("%d listings and %d files fetched, want 1 and 1", lists, opens)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	b := newMemBackend(map[string]string{
+		"/a.txt":     "hello",
+		"/sub/b.txt": "world",
+	})
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	for _, name := range []string{"/a.txt", "/sub/b.txt"} {
+		if _, err := m.Stat(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lists, opens := b.calls()
+
+	// the probes of WebDAV clients are answered from the cached listings
+	for _, name := range []string{
+		"/desktop.ini", "/._a.txt", "/.DS_Store",
+		"/sub/desktop.ini", "/sub/._b.txt", "/sub/.DS_Store",
+		"/missing/desktop.ini", "/._sub/._b.txt", "/sub/missing/.DS_Store",
+	} {
+		if _, err := m.Stat(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%s) = %v, want fs.ErrNotExist", name, err)
+		}
+		if _, err := m.OpenFile(ctx, name, os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("OpenFile(%s) = %v, want fs.ErrNotExist", name, err)
+		}
+	}
+	if l, o := b.calls(); l != lists || o != opens {
+		t.Errorf("probes made %d lists and %d opens, want none", l-lists, o-opens)
+	}
+
+	// without a listing of the parent, missing directories are remembered
+	m = newMemStatikFS(t, b, StatikStaleTime)
+	lists, _ = b.calls()
+	for i := 0; i < 3; i++ {
+		if _, err := m.Stat(ctx, "/missing/desktop.ini"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(/missing/desktop.ini) = %v, want fs.ErrNotExist", err)
+		}
+	}
+	if l, _ := b.calls(); l != lists+1 {
+		t.Errorf("%d lists of a missing directory, want 1", l-lists)
+	}
+
+	// for 30 seconds
+	el, found := m.statiks.lookup(m.baseUrl + "/missing")
+	if !found || !el.missing {
+		t.Fatal("missing directory not cached")
+	}
+	if ttl := time.Until(el.exp); ttl < 29*time.Second || ttl > 30*time.Second {
+		t.Errorf("missing directory cached for %v, want 30s", ttl)
+	}
+
+	expire(m, "/missing")
+	if _, err := m.Stat(ctx, "/missing/desktop.ini"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(/missing/desktop.ini) = %v, want fs.ErrNotExist", err)
+	}
+	if l, _ := b.calls(); l != lists+2 {
+		t.Errorf("%d lists of an expired missing directory, want 1", l-lists-1)
+	}
+}
@@ -0,0 +1,556 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/webdav"
+)
+
+// GitConfig configures the git repositories of the git+file:// base urls.
+type GitConfig struct {
+	CacheDir        string        // directory of the mirrors of the repositories
+	Ref             string        // ref served at the root of the teachings
+	RefreshInterval time.Duration // how often the mirrors are fetched again
+}
+
+// DefaultGitConfig is the GitConfig used until ConfigureGit is called.
+var DefaultGitConfig = GitConfig{
+	CacheDir:        filepath.Join(os.TempDir(), "fileseeker-git"),
+	Ref:             "HEAD",
+	RefreshInterval: 5 * time.Minute,
+}
+
+var gitConfig = DefaultGitConfig
+
+// ConfigureGit configures the repositories of the git+file:// base urls. It
+// must be called before any StatikFS is created.
+func ConfigureGit(cfg GitConfig) {
+	gitConfig = cfg
+}
+
+// GitBackend is a Backend serving the tree of a ref of a git repository,
+// through the git command.
+//
+// The repository is cloned as a mirror in the cache directory when it is
+// first listed, and fetched again in the background every refresh interval.
+// The configured ref is served at the root, and each tag under /@tag/, listed
+// at the root too. Directories of the tree whose name starts with @ are hidden
+// at the root.
+//
+// Files and directories have the time of the last commit changing them, and
+// files have their exact size. Files are opened as StreamFile, so that each
+// read of a blob runs a single git cat-file.
+type GitBackend struct {
+	cfg    GitConfig
+	remote string // url or path of the repository
+	dir    string // path of the mirror
+
+	lock     sync.Mutex
+	cloned   bool          // whether the mirror exists
+	cloning  chan struct{} // closed when the clone in progress ends, nil if none
+	cloneErr error         // error of the last clone
+	fetching bool          // whether the mirror is being fetched
+	fetched  time.Time     // when the mirror was last fetched
+}
+
+// NewGitBackend returns a new GitBackend for the repository at remote, a local
+// path or url.
+func NewGitBackend(cfg GitConfig, remote string) (*GitBackend, error) {
+	if remote == "" {
+		return nil, fmt.Errorf("missing git repository")
+	}
+
+	hash := sha256.Sum256([]byte(remote))
+	return &GitBackend{
+		cfg:    cfg,
+		remote: remote,
+		dir:    filepath.Join(cfg.CacheDir, hex.EncodeToString(hash[:])+".git"),
+	}, nil
+}
+
+// List implements Backend for GitBackend. The validators of a listing identify
+// the commit it comes from.
+func (b *GitBackend) List(ctx context.Context, path string, prev Validators) (Statik, Validators, error) {
+	if err := b.update(ctx); err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	ref, subPath := b.cfg.Ref, strings.Trim(path, "/")
+	if first, rest, _ := strings.Cut(subPath, "/"); strings.HasPrefix(first, "@") {
+		ref, subPath = "refs/tags/"+strings.TrimPrefix(first, "@"), rest
+	}
+	root := path == "/"
+
+	commit, commitTime, err := b.resolveRef(ctx, ref)
+	if err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	version := commit + ":" + subPath
+	var tags []StatikDirInfo
+	if root {
+		if tags, err = b.tags(ctx); err != nil {
+			return Statik{}, Validators{}, err
+		}
+		for _, tag := range tags {
+			version += ":" + tag.Url
+		}
+	}
+
+	hash := sha256.Sum256([]byte(version))
+	validators := Validators{ETag: strconv.Quote(hex.EncodeToString(hash[:16]))}
+	if !prev.IsZero() && prev == validators {
+		return Statik{}, prev, ErrNotModified
+	}
+
+	out, err := b.git(ctx, "ls-tree", "-l", "-z", commit+":"+subPath)
+	if err != nil {
+		// the path is not a directory of the commit
+		return Statik{}, Validators{}, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	statik := Statik{StatikDirInfo: StatikDirInfo{
+		Url:     b.remote + "#" + commit + ":" + subPath,
+		Time:    commitTime,
+		NameRaw: pathpkg.Base(path),
+		Path:    path,
+		SizeRaw: "0 B",
+	}}
+
+	var names []string
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <name>
+		meta, name, found := strings.Cut(string(entry), "\t")
+		fields := strings.Fields(meta)
+		if !found || len(fields) != 4 {
+			continue
+		}
+
+		entryPath := pathpkg.Join(path, name)
+		switch {
+		case fields[1] == "tree" && root && strings.HasPrefix(name, "@"):
+			// hidden by the tags
+			continue
+		case fields[1] == "tree":
+			statik.Directories = append(statik.Directories, StatikDirInfo{
+				Url:     b.remote + "#" + commit + ":" + pathpkg.Join(subPath, name),
+				NameRaw: name,
+				Path:    entryPath,
+				SizeRaw: "0 B",
+			})
+		case fields[1] == "blob" && fields[0] != "120000":
+			// symbolic links are not followed, as they may lead outside of
+			// the tree
+			statik.Files = append(statik.Files, StatikFileInfo{
+				NameRaw: name,
+				Path:    entryPath,
+				Url:     b.remote + "#" + fields[2],
+				Mime:    mimeByExtension(name),
+				SizeRaw: fields[3] + " B",
+			})
+		default:
+			continue
+		}
+		names = append(names, name)
+	}
+
+	times := b.changeTimes(ctx, commit, subPath, names)
+	for i := range statik.Directories {
+		statik.Directories[i].Time = commitTime
+		if t, found := times[statik.Directories[i].NameRaw]; found {
+			statik.Directories[i].Time = t
+		}
+	}
+	for i := range statik.Files {
+		statik.Files[i].Time = commitTime
+		if t, found := times[statik.Files[i].NameRaw]; found {
+			statik.Files[i].Time = t
+		}
+	}
+
+	statik.Directories = append(statik.Directories, tags...)
+
+	return statik, validators, nil
+}
+
+// tags returns the directories of the tags of the repository.
+func (b *GitBackend) tags(ctx context.Context) ([]StatikDirInfo, error) {
+	out, err := b.git(ctx, "for-each-ref", "--format=%(refname:strip=2)%00%(objectname)%00%(creatordate:unix)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []StatikDirInfo
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3 || strings.Contains(fields[0], "/") {
+			// tags with slashes can't be a directory name
+			continue
+		}
+
+		unix, _ := strconv.ParseInt(fields[2], 10, 64)
+		tags = append(tags, StatikDirInfo{
+			Url:     b.remote + "#" + fields[1],
+			Time:    time.Unix(unix, 0),
+			NameRaw: "@" + fields[0],
+			Path:    "/@" + fields[0],
+			SizeRaw: "0 B",
+			tag:     true,
+		})
+	}
+
+	return tags, nil
+}
+
+// resolveRef returns the commit ref points to, and its time.
+func (b *GitBackend) resolveRef(ctx context.Context, ref string) (string, time.Time, error) {
+	if ref == "" || ref == "refs/tags/" || strings.HasPrefix(ref, "-") || strings.ContainsAny(ref, ":^~?*[\\ ") || strings.Contains(ref, "..") {
+		return "", time.Time{}, &fs.PathError{Op: "open", Path: ref, Err: fs.ErrNotExist}
+	}
+
+	out, err := b.git(ctx, "log", "-1", "--format=%H %ct", "--end-of-options", ref+"^{commit}", "--")
+	if err != nil {
+		return "", time.Time{}, &fs.PathError{Op: "open", Path: ref, Err: fs.ErrNotExist}
+	}
+
+	commit, rawTime, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	unix, err := strconv.ParseInt(rawTime, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid commit time %q: %w", rawTime, err)
+	}
+
+	return commit, time.Unix(unix, 0), nil
+}
+
+// changeTimes returns the times of the last commits changing the entries with
+// names of the directory at subPath, walking the history of commit only as far
+// as needed. Entries whose time can't be found are left out.
+func (b *GitBackend) changeTimes(ctx context.Context, commit, subPath string, names []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(names))
+	if len(names) == 0 {
+		return times
+	}
+
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+
+	prefix := ""
+	if subPath != "" {
+		prefix = subPath + "/"
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := []string{"-c", "core.quotePath=false", "--literal-pathspecs", "log", "--format=%x00%ct", "--name-only", "--no-renames", commit, "--"}
+	if subPath != "" {
+		args = append(args, subPath)
+	}
+
+	cmd := b.command(ctx, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		log.Error().Err(err).Str("remote", b.remote).Msg("failed to read git history")
+		return times
+	}
+
+	var current time.Time
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && len(pending) > 0 {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\x00") {
+			if unix, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				current = time.Unix(unix, 0)
+			}
+			continue
+		}
+
+		name, _, _ := strings.Cut(strings.TrimPrefix(line, prefix), "/")
+		if pending[name] {
+			times[name] = current
+			delete(pending, name)
+		}
+	}
+
+	// stop walking the history once every entry has been found
+	cancel()
+	_ = cmd.Wait()
+
+	return times
+}
+
+// Stat implements Backend for GitBackend.
+func (b *GitBackend) Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error) {
+	blob, err := b.blob(file)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	out, err := b.git(ctx, "cat-file", "-s", blob)
+	if err != nil {
+		return ObjectInfo{}, &fs.PathError{Op: "stat", Path: file.Url, Err: fs.ErrNotExist}
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Validators: Validators{ETag: strconv.Quote(blob)}, Size: size}, nil
+}
+
+// Open implements Backend for GitBackend. Blobs are read from their start, so
+// reading a range far from the start of a large file is slow.
+func (b *GitBackend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	blobId, err := b.blob(file)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	// the size of blobs in the listings is exact
+	info := ObjectInfo{Validators: Validators{ETag: strconv.Quote(blobId)}, Size: file.Size(), Offset: offset}
+	if !prev.IsZero() && prev == info.Validators {
+		return nil, info, ErrNotModified
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := b.command(ctx, "cat-file", "blob", blobId)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		return nil, ObjectInfo{}, err
+	}
+
+	blob := &gitBlobReader{ReadCloser: stdout, cmd: cmd, cancel: cancel}
+	if _, err = io.CopyN(io.Discard, blob, offset); err != nil && err != io.EOF {
+		blob.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	if length < 0 {
+		return blob, info, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(blob, length), blob}, info, nil
+}
+
+// OpenFile implements FileOpener for GitBackend, returning a StreamFile, so
+// that a blob is read by a single git cat-file however it is seeked.
+func (b *GitBackend) OpenFile(ctx context.Context, file StatikFileInfo) (webdav.File, error) {
+	if _, err := b.blob(file); err != nil {
+		return nil, err
+	}
+	return NewStreamFile(ctx, b, file), nil
+}
+
+// gitBlobReader reads a blob from the output of git cat-file, stopping the
+// command when it is closed.
+type gitBlobReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+}
+
+func (r *gitBlobReader) Close() error {
+	r.cancel()
+	_ = r.ReadCloser.Close()
+	_ = r.cmd.Wait()
+	return nil
+}
+
+// blob returns the id of the blob of file, whose url was set by List.
+func (b *GitBackend) blob(file StatikFileInfo) (string, error) {
+	blob := strings.TrimPrefix(file.Url, b.remote+"#")
+	if blob == file.Url || blob == "" || strings.HasPrefix(blob, "-") || strings.ContainsAny(blob, ":^~ ") {
+		return "", &fs.PathError{Op: "open", Path: file.Url, Err: fs.ErrNotExist}
+	}
+	return blob, nil
+}
+
+// update clones the repository if it is not cloned yet, or fetches it again in
+// the background if it was fetched more than a refresh interval ago.
+func (b *GitBackend) update(ctx context.Context) error {
+	if err := b.waitClone(ctx); err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.fetching && time.Since(b.fetched) >= b.cfg.RefreshInterval {
+		b.fetching = true
+		go b.fetch()
+	}
+
+	return nil
+}
+
+// waitClone clones the repository if it is not cloned yet, and waits for the
+// clone to end or for ctx to be done.
+//
+// The clone is made in the background and shared by all the callers waiting
+// for it, so that it isn't cancelled with the request that started it.
+func (b *GitBackend) waitClone(ctx context.Context) error {
+	b.lock.Lock()
+	if b.cloned {
+		b.lock.Unlock()
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(b.dir, "HEAD")); err == nil {
+		// cloned by a previous run, fetch it as soon as possible
+		b.cloned = true
+		b.lock.Unlock()
+		return nil
+	}
+
+	if b.cloning == nil {
+		b.cloning = make(chan struct{})
+		go b.clone(ctx)
+	}
+	cloning := b.cloning
+	b.lock.Unlock()
+
+	select {
+	case <-cloning:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.cloned {
+		return b.cloneErr
+	}
+	return nil
+}
+
+// clone clones the repository as a mirror, in a span linked to the one of ctx
+// but without its cancellation, then wakes up the callers of update waiting for
+// it.
+func (b *GitBackend) clone(ctx context.Context) {
+	ctx, span := tr.Start(context.Background(), "git.clone",
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("remote", b.remote)))
+	defer span.End()
+
+	err := b.cloneMirror(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to clone git repository")
+		log.Error().Err(err).Str("remote", b.remote).Msg("failed to clone git repository")
+	}
+
+	b.lock.Lock()
+	if err == nil {
+		b.cloned = true
+		b.fetched = time.Now()
+	}
+	b.cloneErr = err
+	close(b.cloning)
+	b.cloning = nil
+	b.lock.Unlock()
+}
+
+// cloneMirror clones the repository as a mirror in the cache directory.
+func (b *GitBackend) cloneMirror(ctx context.Context) error {
+	log.Info().Str("remote", b.remote).Str("dir", b.dir).Msg("cloning git repository")
+
+	if err := os.MkdirAll(b.cfg.CacheDir, 0755); err != nil {
+		return err
+	}
+
+	// clone to a temporary directory, so that a failed clone leaves nothing
+	// behind
+	tmp, err := os.MkdirTemp(b.cfg.CacheDir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	cmd := exec.CommandContext(ctx, "git", "clone", "--quiet", "--mirror", "--end-of-options", b.remote, tmp)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error cloning %s: %w: %s", b.remote, err, bytes.TrimSpace(out))
+	}
+
+	if err = os.RemoveAll(b.dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.dir)
+}
+
+// fetch fetches the mirror again.
+func (b *GitBackend) fetch() {
+	ctx, span := tr.Start(context.Background(), "git.fetch")
+	span.SetAttributes(attribute.String("remote", b.remote))
+	defer span.End()
+
+	_, err := b.git(ctx, "fetch", "--quiet", "--prune", "origin")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to fetch git repository")
+		log.Warn().Err(err).Str("remote", b.remote).Msg("failed to fetch git repository")
+	}
+
+	b.lock.Lock()
+	b.fetching = false
+	b.fetched = time.Now()
+	b.lock.Unlock()
+}
+
+// git runs git with args in the mirror, returning its output.
+func (b *GitBackend) git(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, span := tr.Start(ctx, "git")
+	span.SetAttributes(attribute.StringSlice("args", args))
+	defer span.End()
+
+	var stderr bytes.Buffer
+	cmd := b.command(ctx, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("git %s: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "git failed")
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// command returns the command running git with args in the mirror.
+func (b *GitBackend) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", b.dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd
+}
@@ -0,0 +1,152 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRepo returns the path of a git repository with a file a.md, changed
+// by a second commit, a tag v1 of the first commit, and a file notes/n.txt.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := filepath.Join(t.TempDir(), "teaching")
+	git := func(date string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com", "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, contents string) {
+		t.Helper()
+		name = filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("a.md", "v1")
+	write("notes/n.txt", "note")
+	git("2020-01-01T00:00:00Z", "init", "-q")
+	git("2020-01-01T00:00:00Z", "add", ".")
+	git("2020-01-01T00:00:00Z", "commit", "-qm", "first")
+	git("2020-01-01T00:00:00Z", "tag", "v1")
+	write("a.md", "version 2")
+	git("2021-01-01T00:00:00Z", "commit", "-qam", "second")
+
+	return repo
+}
+
+// newTestGitBackend returns a GitBackend for repo, with its mirror in a
+// temporary directory.
+func newTestGitBackend(t *testing.T, repo string) *GitBackend {
+	t.Helper()
+
+	cfg := GitConfig{CacheDir: filepath.Join(t.TempDir(), "cache"), Ref: "HEAD", RefreshInterval: time.Hour}
+	b, err := NewGitBackend(cfg, "file://"+repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGitBackend(t *testing.T) {
+	b := newTestGitBackend(t, newTestRepo(t))
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	for name, want := range map[string]string{
+		"/a.md":        "version 2",
+		"/@v1/a.md":    "v1",
+		"/notes/n.txt": "note",
+	} {
+		if got := readAll(t, m, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	f, err := m.OpenFile(ctx, "/a.md", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(*StreamFile); !ok {
+		t.Errorf("OpenFile = %T, want *StreamFile", f)
+	}
+
+	if _, err = f.Seek(8, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := io.ReadAll(f); err != nil || string(contents) != "2" {
+		t.Errorf("read %q, %v at 8", contents, err)
+	}
+
+	for _, name := range []string{"/@nope/a.md", "/@--help/a.md", "/missing.md"} {
+		if _, err := m.OpenFile(ctx, name, os.O_RDONLY, 0); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("OpenFile(%s) = %v, want fs.ErrNotExist", name, err)
+		}
+	}
+}
+
+func TestGitWalkTags(t *testing.T) {
+	b := newTestGitBackend(t, newTestRepo(t))
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	walk := func(root string) string {
+		var names []string
+		err := m.Walk(ctx, root, func(name string, file StatikFileInfo) error {
+			names = append(names, name)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk(%s): %v", root, err)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// the tags are left out of walks from the root, but not from inside them
+	if got := walk("/"); got != "/a.md,/notes/n.txt" {
+		t.Errorf("Walk(/) = %s", got)
+	}
+	if got := walk("/@v1"); got != "/@v1/a.md,/@v1/notes/n.txt" {
+		t.Errorf("Walk(/@v1) = %s", got)
+	}
+}
+
+func TestGitCloneOutlivesRequest(t *testing.T) {
+	b := newTestGitBackend(t, newTestRepo(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := b.List(ctx, "/", Validators{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("List = %v, want context.Canceled", err)
+	}
+
+	// the clone started by the cancelled request goes on
+	statik, _, err := b.List(context.Background(), "/", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := statik.file("a.md"); !found {
+		t.Errorf("a.md not listed: %v", statik.Files)
+	}
+}
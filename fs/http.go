@@ -1,20 +1,293 @@
 package fs
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 )
 
-var httpClient = &http.Client{}
+// HTTPConfig configures the client used for the requests to the upstream
+// server.
+type HTTPConfig struct {
+	ConnectTimeout  time.Duration // timeout for establishing a connection
+	HeaderTimeout   time.Duration // timeout for receiving the response headers
+	ReadTimeout     time.Duration // timeout for receiving more of the response body, while it is being read
+	Retries         int           // how many times failed requests are retried
+	RetryBackoff    time.Duration // delay before the first retry, doubled at each retry up to maxRetryBackoff
+	MaxConnsPerHost int           // maximum number of connections per upstream host, 0 for no limit
+	UserAgent       string        // User-Agent header of the requests
 
-func httpGet(ctx context.Context, url string) (*http.Response, error) {
-	ctx, span := tr.Start(ctx, "httpGet",
-		trace.WithSpanKind(trace.SpanKindClient),
-		trace.WithAttributes(attribute.String("url", url)))
-	defer span.End()
+	BreakerThreshold int           // consecutive failures opening the circuit breaker of a host, 0 to disable it
+	BreakerCooldown  time.Duration // how long an open circuit breaker fails requests before probing the host again
+}
+
+// DefaultHTTPConfig is the HTTPConfig used until ConfigureHTTP is called.
+var DefaultHTTPConfig = HTTPConfig{
+	ConnectTimeout:  10 * time.Second,
+	HeaderTimeout:   30 * time.Second,
+	ReadTimeout:     time.Minute,
+	Retries:         2,
+	RetryBackoff:    200 * time.Millisecond,
+	MaxConnsPerHost: 64,
+	UserAgent:       "fileseeker",
+
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+// maxRetryBackoff is the maximum delay between two retries.
+const maxRetryBackoff = time.Minute
+
+var (
+	httpConfig = DefaultHTTPConfig
+	httpClient = newHTTPClient(DefaultHTTPConfig)
+)
+
+// tracedHeaders are the request headers recorded in the spans of the upstream
+// requests. The others are left out, since they may hold credentials.
+var tracedHeaders = []string{"Range", "If-None-Match", "If-Modified-Since"}
+
+// ConfigureHTTP configures the client used for the requests to the upstream
+// server. It must be called before any StatikFS is used.
+func ConfigureHTTP(cfg HTTPConfig) error {
+	switch {
+	case cfg.ConnectTimeout < 0, cfg.HeaderTimeout < 0, cfg.ReadTimeout < 0:
+		return errors.New("negative upstream timeout")
+	case cfg.Retries < 0:
+		return errors.New("negative number of upstream retries")
+	case cfg.RetryBackoff < 0:
+		return errors.New("negative upstream retry backoff")
+	case cfg.MaxConnsPerHost < 0:
+		return errors.New("negative maximum number of upstream connections")
+	case cfg.BreakerThreshold < 0, cfg.BreakerCooldown < 0:
+		return errors.New("negative upstream circuit breaker threshold or cooldown")
+	}
+
+	httpConfig = cfg
+	httpClient = newHTTPClient(cfg)
+	return nil
+}
+
+func newHTTPClient(cfg HTTPConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = cfg.ConnectTimeout
+	transport.ResponseHeaderTimeout = cfg.HeaderTimeout
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.MaxIdleConnsPerHost = cfg.MaxConnsPerHost
+
+	// no timeout for the whole request: the bodies of large files may take
+	// long to stream, and are limited by cfg.ReadTimeout instead
+	return &http.Client{Transport: tracingTransport{base: transport}}
+}
+
+// httpGet performs a GET request to url, adding the given headers (which may
+// be nil) to the request, as httpRequest does.
+func httpGet(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	return httpRequest(ctx, http.MethodGet, url, header)
+}
+
+// httpRequest performs a request with method to url, adding the given headers
+// (which may be nil) to the request.
+//
+// Requests failing because of network errors or of a 502, 503 or 504 status
+// are retried with a jittered exponential backoff, as configured by
+// ConfigureHTTP. Requests to a host whose circuit breaker is open fail with
+// ErrCircuitOpen without being made.
+//
+// The span of httpRequest ends when the body of the returned response is
+// closed.
+func httpRequest(ctx context.Context, method, url string, header http.Header) (resp *http.Response, err error) {
+	ctx, span := tr.Start(ctx, "httpRequest",
+		trace.WithAttributes(
+			attribute.String("http.method", method),
+			attribute.String("url", url),
+			attribute.String("http.user_agent", httpConfig.UserAgent),
+			attribute.String("http.timeout.connect", httpConfig.ConnectTimeout.String()),
+			attribute.String("http.timeout.header", httpConfig.HeaderTimeout.String()),
+			attribute.String("http.timeout.read", httpConfig.ReadTimeout.String()),
+			attribute.Int("http.retries.max", httpConfig.Retries),
+			attribute.Int("http.max_conns_per_host", httpConfig.MaxConnsPerHost),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "upstream request failed")
+			span.End()
+		} else {
+			resp.Body = newTracedBody(resp.Body, span)
+		}
+	}()
+
+	breaker := breakerFor(url)
+
+	backoff := httpConfig.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		allowed, state := breaker.allow()
+		span.SetAttributes(
+			attribute.Int("http.attempts", attempt+1),
+			attribute.String("circuit.state", state.String()))
+		if !allowed {
+			return nil, &UpstreamError{Url: url, Kind: ErrUpstreamUnavailable, Err: ErrCircuitOpen}
+		}
+
+		resp, err = httpDo(ctx, method, url, header)
+		breaker.done(ctx, state, resp, err)
+		if attempt >= httpConfig.Retries || !shouldRetry(ctx, resp, err) {
+			if resp != nil {
+				span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+			}
+			return resp, err
+		}
+
+		if err != nil {
+			span.RecordError(err)
+		} else {
+			span.AddEvent("retryable status", trace.WithAttributes(attribute.Int("http.status_code", resp.StatusCode)))
+			_ = resp.Body.Close()
+		}
+
+		// full jitter between half and all of the backoff
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		log.Debug().Str("url", url).Int("attempt", attempt+1).Dur("delay", delay).Msg("retrying upstream request")
+
+		select {
+		case <-ctx.Done():
+			return nil, &UpstreamError{Url: url, Kind: ErrUpstreamUnavailable, Err: ctx.Err()}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// httpDo performs a single request with method to url with the given headers.
+// Reading the body of the response fails if no data is received for the
+// configured ReadTimeout.
+func httpDo(ctx context.Context, method, url string, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	for _, key := range tracedHeaders {
+		if values := req.Header.Values(key); len(values) > 0 {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.StringSlice("http.request.header."+key, values))
+		}
+	}
+	if httpConfig.UserAgent != "" {
+		req.Header.Set("User-Agent", httpConfig.UserAgent)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, &UpstreamError{Url: url, Kind: ErrUpstreamUnavailable, Err: err}
+	}
+
+	resp.Body = newIdleTimeoutBody(url, resp.Body, httpConfig.ReadTimeout, cancel)
+	return resp, nil
+}
+
+// idleTimeoutBody is the body of a response, whose request is canceled if a
+// read doesn't receive any data for timeout. The time between reads doesn't
+// count, so that slow readers are not cut off.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	url      string
+	timeout  time.Duration // 0 for no timeout
+	cancel   context.CancelFunc
+	timer    *time.Timer // running during reads
+	timedOut atomic.Bool
+}
+
+func newIdleTimeoutBody(url string, body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, url: url, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, func() {
+			b.timedOut.Store(true)
+			cancel()
+		})
+		b.timer.Stop()
+	}
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if b.timer != nil {
+		b.timer.Reset(b.timeout)
+		defer b.timer.Stop()
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.timedOut.Load() {
+		err = &UpstreamError{Url: b.url, Kind: ErrUpstreamUnavailable, Err: fmt.Errorf("no data received for %v: %w", b.timeout, context.DeadlineExceeded)}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// shouldRetry reports whether a request returning resp and err should be
+// retried.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// readBody reads body, the contents of url announcing size bytes (-1 if
+// unknown), failing with ErrUpstreamTooLarge if it is bigger than
+// maxBufferedSize.
+func readBody(url string, body io.Reader, size int64) (*bytes.Buffer, error) {
+	if size > maxBufferedSize {
+		return nil, &UpstreamError{Url: url, Kind: ErrUpstreamTooLarge}
+	}
+
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(io.LimitReader(body, maxBufferedSize+1))
+	if err != nil {
+		return nil, &UpstreamError{Url: url, Kind: ErrUpstreamUnavailable, Err: err}
+	}
+	if buf.Len() > maxBufferedSize {
+		return nil, &UpstreamError{Url: url, Kind: ErrUpstreamTooLarge}
+	}
 
-	return httpClient.Get(url)
+	return &buf, nil
 }
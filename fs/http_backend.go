@@ -0,0 +1,201 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// HTTPBackend is a Backend serving the statik.json files and the files of a
+// remote server, through the client configured by ConfigureHTTP.
+//
+// The listing of each directory is the statik.json file in it, and files are
+// fetched from the urls in the listings, with Range requests when only a part
+// of them is needed.
+type HTTPBackend struct {
+	baseUrl string // base url of the remote server
+}
+
+// NewHTTPBackend returns a new HTTPBackend for the remote server at base url.
+func NewHTTPBackend(base string) *HTTPBackend {
+	return &HTTPBackend{baseUrl: base}
+}
+
+// List implements Backend for HTTPBackend.
+func (b *HTTPBackend) List(ctx context.Context, path string, prev Validators) (Statik, Validators, error) {
+	url := b.baseUrl + path + "/statik.json"
+
+	resp, err := httpGet(ctx, url, conditionalHeader(prev))
+	if err != nil {
+		return Statik{}, Validators{}, fmt.Errorf("error getting statik.json: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && !prev.IsZero() {
+		return Statik{}, prev, ErrNotModified
+	}
+	if err = checkStatus(url, resp); err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	var statik Statik
+	err = json.NewDecoder(resp.Body).Decode(&statik)
+	if err != nil {
+		return Statik{}, Validators{}, fmt.Errorf("error decoding statik.json: %w", err)
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return Statik{}, Validators{}, fmt.Errorf("error closing response body: %w", err)
+	}
+
+	return statik, responseValidators(resp), nil
+}
+
+// Stat implements Backend for HTTPBackend. The size is taken from the answer to
+// a request for the first byte of the file.
+func (b *HTTPBackend) Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error) {
+	header := http.Header{}
+	header.Set("Range", "bytes=0-0")
+
+	resp, err := httpGet(ctx, file.Url, header)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	info := ObjectInfo{Validators: responseValidators(resp), Size: resp.ContentLength}
+	switch resp.StatusCode {
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		_, info.Size, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return ObjectInfo{}, &UpstreamError{Url: file.Url, StatusCode: resp.StatusCode, Kind: ErrUpstreamUnavailable, Err: err}
+		}
+	default:
+		if err = checkStatus(file.Url, resp); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+
+	return info, nil
+}
+
+// Open implements Backend for HTTPBackend. If the remote server ignores the
+// Range request and sends the whole file, the file is read in memory to learn
+// its size.
+func (b *HTTPBackend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	if length == 0 {
+		info, err := b.Stat(ctx, file)
+		if err != nil {
+			return nil, ObjectInfo{}, err
+		}
+		info.Offset = offset
+		return http.NoBody, info, nil
+	}
+
+	return httpOpen(ctx, file.Url, nil, offset, length, prev)
+}
+
+// httpOpen implements Backend.Open for the file at url, adding the given
+// headers (which may be nil) to the request. The length must not be 0.
+func httpOpen(ctx context.Context, url string, header http.Header, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	for key, values := range conditionalHeader(prev) {
+		header[key] = values
+	}
+
+	ranged := offset > 0 || length >= 0
+	if ranged {
+		rng := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			rng += strconv.FormatInt(offset+length-1, 10)
+		}
+		header.Set("Range", rng)
+	}
+
+	resp, err := httpGet(ctx, url, header)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{Validators: responseValidators(resp), Size: resp.ContentLength}
+	if resp.StatusCode == http.StatusNotModified && !prev.IsZero() {
+		resp.Body.Close()
+		return nil, info, ErrNotModified
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		info.Offset, info.Size, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, ObjectInfo{}, &UpstreamError{Url: url, StatusCode: resp.StatusCode, Kind: ErrUpstreamUnavailable, Err: err}
+		}
+		return resp.Body, info, nil
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// we're past the end of the file
+		resp.Body.Close()
+		_, info.Size, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, ObjectInfo{}, &UpstreamError{Url: url, StatusCode: resp.StatusCode, Kind: ErrUpstreamUnavailable, Err: err}
+		}
+		info.Offset = offset
+		return http.NoBody, info, nil
+	}
+
+	// never read error pages as file contents
+	if err = checkStatus(url, resp); err != nil {
+		resp.Body.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	if !ranged {
+		return resp.Body, info, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ObjectInfo{}, &UpstreamError{Url: url, StatusCode: resp.StatusCode, Kind: ErrUpstreamUnavailable}
+	}
+
+	// the upstream doesn't support ranges and sent the whole file
+	buf, err := readBody(url, resp.Body, resp.ContentLength)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	info.Size = int64(buf.Len())
+	return io.NopCloser(bytes.NewReader(buf.Bytes())), info, nil
+}
+
+// conditionalHeader returns the headers of a request conditional on v, or nil
+// if v is zero.
+func conditionalHeader(v Validators) http.Header {
+	if v.IsZero() {
+		return nil
+	}
+
+	header := http.Header{}
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("If-Modified-Since", v.LastModified)
+	}
+	return header
+}
+
+// responseValidators returns the validators of resp.
+func responseValidators(resp *http.Response) Validators {
+	return Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
@@ -0,0 +1,132 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRevalidatingServer returns a server of a statik.json listing the file
+// f.txt, both with the ETag "v1". If broken is true, it answers 304 to every
+// request, conditional or not. It counts the requests answered with 304.
+func newRevalidatingServer(t *testing.T, broken bool) (*httptest.Server, *int32) {
+	var notModified int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if broken || r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/statik.json", "//statik.json":
+			io.WriteString(w, `{"files": [{"name": "f.txt", "url": "`+srv.URL+`/f.txt", "size": "5 B"}]}`)
+		case "/f.txt":
+			http.ServeContent(w, r, "f.txt", time.Time{}, strings.NewReader("hello"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &notModified
+}
+
+func TestHTTPBackendRevalidation(t *testing.T) {
+	srv, notModified := newRevalidatingServer(t, false)
+	b := NewHTTPBackend(srv.URL)
+	ctx := context.Background()
+	file := StatikFileInfo{NameRaw: "f.txt", Url: srv.URL + "/f.txt"}
+
+	statik, v, err := b.List(ctx, "", Validators{})
+	if err != nil || len(statik.Files) != 1 || v.ETag != `"v1"` {
+		t.Fatalf("List = %+v, %+v, %v", statik, v, err)
+	}
+	if _, v, err = b.List(ctx, "", v); !errors.Is(err, ErrNotModified) || v.ETag != `"v1"` {
+		t.Errorf("List revalidating = %+v, %v, want ErrNotModified", v, err)
+	}
+
+	body, info, err := b.Open(ctx, file, 0, -1, Validators{})
+	if err != nil || info.ETag != `"v1"` {
+		t.Fatalf("Open = %+v, %v", info, err)
+	}
+	body.Close()
+	if _, _, err = b.Open(ctx, file, 0, -1, info.Validators); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Open revalidating = %v, want ErrNotModified", err)
+	}
+	if _, _, err = b.Open(ctx, file, 2, 2, info.Validators); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Open of a range revalidating = %v, want ErrNotModified", err)
+	}
+
+	if n := atomic.LoadInt32(notModified); n != 3 {
+		t.Errorf("%d requests answered with 304, want 3", n)
+	}
+}
+
+func TestHTTPBackendUnexpectedNotModified(t *testing.T) {
+	srv, _ := newRevalidatingServer(t, true)
+	b := NewHTTPBackend(srv.URL)
+	ctx := context.Background()
+	file := StatikFileInfo{NameRaw: "f.txt", Url: srv.URL + "/f.txt"}
+
+	// a 304 to a request without validators is an upstream error, never an
+	// empty listing or file
+	var errs []error
+	_, _, err := b.List(ctx, "", Validators{})
+	errs = append(errs, err)
+	_, err = b.Stat(ctx, file)
+	errs = append(errs, err)
+	for _, length := range []int64{-1, 10, 0} {
+		_, _, err = b.Open(ctx, file, 0, length, Validators{})
+		errs = append(errs, err)
+	}
+
+	for i, err := range errs {
+		var upstreamErr *UpstreamError
+		if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != http.StatusNotModified || HTTPStatus(err) != http.StatusBadGateway {
+			t.Errorf("call %d = %v, want an upstream error", i, err)
+		}
+	}
+}
+
+func TestStatikFSRevalidation(t *testing.T) {
+	srv, notModified := newRevalidatingServer(t, false)
+	cache := NewStatikCache(DefaultStatikCacheSize, StatikStaleTime)
+	t.Cleanup(cache.Close)
+	m, err := NewStatikFS(srv.URL, WithStatikCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an expired listing is revalidated in the background, and kept when
+	// unchanged
+	if got := readAll(t, m, "/f.txt"); got != "hello" {
+		t.Errorf("/f.txt = %q, want %q", got, "hello")
+	}
+	expire(m, "/")
+	if got := readAll(t, m, "/f.txt"); got != "hello" {
+		t.Errorf("/f.txt = %q, want %q", got, "hello")
+	}
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(notModified) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(notModified); n != 1 {
+		t.Fatalf("%d requests answered with 304, want 1", n)
+	}
+
+	dir, err := m.OpenFile(context.Background(), "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := dir.Readdir(0); err != nil || names(infos) != "f.txt" {
+		t.Errorf("revalidated listing = %s, %v", names(infos), err)
+	}
+}
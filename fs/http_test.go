@@ -0,0 +1,381 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanForwarder is a span processor passing the spans to the recorder of the
+// running test, if any.
+type spanForwarder struct {
+	lock     sync.Mutex
+	recorder *tracetest.SpanRecorder
+}
+
+func (f *spanForwarder) set(recorder *tracetest.SpanRecorder) {
+	f.lock.Lock()
+	f.recorder = recorder
+	f.lock.Unlock()
+}
+
+func (f *spanForwarder) get() *tracetest.SpanRecorder {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.recorder
+}
+
+func (f *spanForwarder) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	if recorder := f.get(); recorder != nil {
+		recorder.OnStart(ctx, s)
+	}
+}
+
+func (f *spanForwarder) OnEnd(s sdktrace.ReadOnlySpan) {
+	if recorder := f.get(); recorder != nil {
+		recorder.OnEnd(s)
+	}
+}
+
+func (f *spanForwarder) Shutdown(context.Context) error   { return nil }
+func (f *spanForwarder) ForceFlush(context.Context) error { return nil }
+
+var (
+	testSpans          = &spanForwarder{}
+	testTracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpans))
+)
+
+// recordSpans returns a recorder of the spans of the test, and injects the
+// trace context in the upstream requests.
+//
+// The tracers created before the global tracer provider is first set, like
+// tr, keep using that provider: a single one is set, passing the spans to the
+// recorder of the running test. The global provider and propagator are
+// restored when the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		testSpans.set(nil)
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	testSpans.set(recorder)
+	otel.SetTracerProvider(testTracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+// endedSpans returns the names of the spans ended in recorder.
+func endedSpans(recorder *tracetest.SpanRecorder) map[string]bool {
+	ended := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		ended[span.Name()] = true
+	}
+	return ended
+}
+
+func TestTracedHeaders(t *testing.T) {
+	recorder := recordSpans(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=secret")
+	header.Set("X-Amz-Security-Token", "secret")
+	header.Set("X-Amz-Date", "20130524T000000Z")
+	header.Set("Range", "bytes=0-9")
+	header.Set("If-None-Match", `"etag"`)
+
+	resp, err := httpGet(context.Background(), srv.URL, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	traced := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		for _, attr := range span.Attributes() {
+			if key := string(attr.Key); strings.HasPrefix(key, "http.request.header.") {
+				traced[strings.TrimPrefix(key, "http.request.header.")] = true
+			}
+		}
+	}
+
+	for _, key := range []string{"Range", "If-None-Match"} {
+		if !traced[key] {
+			t.Errorf("%s header not traced", key)
+		}
+	}
+	for _, key := range []string{"Authorization", "X-Amz-Security-Token", "X-Amz-Date"} {
+		if traced[key] {
+			t.Errorf("%s header traced", key)
+		}
+	}
+}
+
+// newTestHTTPConfig returns the configuration of fast retries and no circuit
+// breaker, used by the tests of the upstream client.
+func newTestHTTPConfig() HTTPConfig {
+	cfg := DefaultHTTPConfig
+	cfg.RetryBackoff = time.Millisecond
+	cfg.BreakerThreshold = 0
+	return cfg
+}
+
+func TestHTTPRetries(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		failures int32 // requests failing before a 200
+		fail     func(w http.ResponseWriter)
+		retries  int
+		calls    int32
+		status   int // 0 for an error
+	}{
+		{"5xx", 2, func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }, 2, 3, http.StatusOK},
+		{"5xx exhausted", 3, func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) }, 2, 3, http.StatusBadGateway},
+		{"no retries", 3, func(w http.ResponseWriter) { w.WriteHeader(http.StatusGatewayTimeout) }, 0, 1, http.StatusGatewayTimeout},
+		{"4xx", 3, func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }, 2, 1, http.StatusNotFound},
+		{"500", 3, func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) }, 2, 1, http.StatusInternalServerError},
+		{"connection errors", 1, func(w http.ResponseWriter) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}, 2, 2, http.StatusOK},
+		{"connection errors exhausted", 5, func(w http.ResponseWriter) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}, 3, 4, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestHTTPConfig()
+			cfg.Retries = test.retries
+			withHTTPConfig(t, cfg)
+
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= test.failures {
+					test.fail(w)
+				}
+			}))
+			defer srv.Close()
+
+			resp, err := httpGet(context.Background(), srv.URL, nil)
+			if test.status == 0 && err == nil {
+				resp.Body.Close()
+				t.Errorf("status %d, want an error", resp.StatusCode)
+			} else if test.status != 0 && err != nil {
+				t.Errorf("error %v, want status %d", err, test.status)
+			} else if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != test.status {
+					t.Errorf("status %d, want %d", resp.StatusCode, test.status)
+				}
+			}
+			if n := atomic.LoadInt32(&calls); n != test.calls {
+				t.Errorf("%d requests, want %d", n, test.calls)
+			}
+		})
+	}
+}
+
+func TestHTTPHeaderTimeout(t *testing.T) {
+	cfg := newTestHTTPConfig()
+	cfg.Retries = 0
+	cfg.HeaderTimeout = 50 * time.Millisecond
+	withHTTPConfig(t, cfg)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := httpGet(context.Background(), srv.URL, nil)
+	if HTTPStatus(err) != http.StatusGatewayTimeout {
+		t.Errorf("error %v, want a 504 upstream error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+}
+
+func TestHTTPReadTimeout(t *testing.T) {
+	cfg := newTestHTTPConfig()
+	cfg.Retries = 0
+	cfg.ReadTimeout = 100 * time.Millisecond
+	withHTTPConfig(t, cfg)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a chunk every 20ms, for much longer than the read timeout
+		for i := 0; i < 25; i++ {
+			io.WriteString(w, "chunk\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		if r.URL.Path == "/stall" {
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// slow bodies and slow readers are not cut off
+	for _, pause := range []time.Duration{0, 150 * time.Millisecond} {
+		resp, err := httpGet(context.Background(), srv.URL+"/slow", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(pause)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || len(body) != 25*len("chunk\n") {
+			t.Errorf("read %d bytes, %v after pausing %v", len(body), err, pause)
+		}
+	}
+
+	// stalled bodies are
+	resp, err := httpGet(context.Background(), srv.URL+"/stall", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if HTTPStatus(err) != http.StatusGatewayTimeout || len(body) != 25*len("chunk\n") {
+		t.Errorf("read %d bytes, %v, want a 504 upstream error", len(body), err)
+	}
+}
+
+func TestHTTPMaxConnsPerHost(t *testing.T) {
+	cfg := newTestHTTPConfig()
+	cfg.MaxConnsPerHost = 2
+	withHTTPConfig(t, cfg)
+
+	var active, peak int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		<-release
+	}))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := httpGet(context.Background(), srv.URL, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&active) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if p := atomic.LoadInt32(&peak); p != 2 {
+		t.Errorf("%d concurrent connections, want 2", p)
+	}
+}
+
+func TestConfigureHTTP(t *testing.T) {
+	prev := httpConfig
+	for _, change := range []func(*HTTPConfig){
+		func(cfg *HTTPConfig) { cfg.ConnectTimeout = -1 },
+		func(cfg *HTTPConfig) { cfg.HeaderTimeout = -1 },
+		func(cfg *HTTPConfig) { cfg.ReadTimeout = -1 },
+		func(cfg *HTTPConfig) { cfg.Retries = -1 },
+		func(cfg *HTTPConfig) { cfg.RetryBackoff = -time.Second },
+		func(cfg *HTTPConfig) { cfg.MaxConnsPerHost = -1 },
+		func(cfg *HTTPConfig) { cfg.BreakerThreshold = -1 },
+		func(cfg *HTTPConfig) { cfg.BreakerCooldown = -1 },
+	} {
+		cfg := DefaultHTTPConfig
+		change(&cfg)
+		if err := ConfigureHTTP(cfg); err == nil {
+			t.Errorf("ConfigureHTTP(%+v) succeeded", cfg)
+		}
+		if httpConfig != prev {
+			t.Fatalf("configuration changed to %+v", httpConfig)
+		}
+	}
+}
+
+func TestTracedUpstreamRequests(t *testing.T) {
+	recorder := recordSpans(t)
+
+	traceparents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer srv.Close()
+
+	ctx, parent := tr.Start(context.Background(), "test")
+	defer parent.End()
+
+	// the trace context is injected in the request
+	resp, err := httpGet(ctx, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceID := parent.SpanContext().TraceID().String()
+	if traceparent := <-traceparents; !strings.Contains(traceparent, traceID) {
+		t.Errorf("traceparent %q, want one of trace %s", traceparent, traceID)
+	}
+
+	// the spans end when the body is read until its end, not before
+	if _, err := io.ReadFull(resp.Body, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if ended := endedSpans(recorder); ended["httpRequest"] || ended["HTTP GET"] {
+		t.Errorf("spans %v ended before reading the body", ended)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if ended := endedSpans(recorder); !ended["httpRequest"] || !ended["HTTP GET"] {
+		t.Errorf("spans %v ended after reading the body, want httpRequest and HTTP GET", ended)
+	}
+	resp.Body.Close()
+
+	// or when it is closed
+	recorder = recordSpans(t)
+	resp, err = httpGet(ctx, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-traceparents
+	if ended := endedSpans(recorder); ended["httpRequest"] || ended["HTTP GET"] {
+		t.Errorf("spans %v ended before closing the body", ended)
+	}
+	resp.Body.Close()
+	if ended := endedSpans(recorder); !ended["httpRequest"] || !ended["HTTP GET"] {
+		t.Errorf("spans %v ended after closing the body, want httpRequest and HTTP GET", ended)
+	}
+}
@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingTransport is a http.RoundTripper that traces every request to the
+// upstream server with a client span, and injects the trace context in the
+// request headers with the global propagator, so that the upstream server can
+// continue the trace.
+//
+// The span ends when the response body is closed or read until its end, and
+// records the size of the body and how long it took to read it.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper for tracingTransport.
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tr.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String()),
+			attribute.String("net.peer.name", req.URL.Hostname()),
+		))
+
+	// RoundTrip must not modify the request
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.ContentLength >= 0 {
+		span.SetAttributes(attribute.Int64("http.response_content_length", resp.ContentLength))
+	}
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+
+	resp.Body = newTracedBody(resp.Body, span)
+	return resp, nil
+}
+
+// tracedBody is a response body ending a span when it is closed or read until
+// its end.
+type tracedBody struct {
+	io.ReadCloser
+	span  trace.Span
+	start time.Time // when the body started being read
+	read  int64     // bytes read
+	once  sync.Once
+}
+
+func newTracedBody(body io.ReadCloser, span trace.Span) *tracedBody {
+	return &tracedBody{ReadCloser: body, span: span, start: time.Now()}
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	if err == io.EOF {
+		b.end(nil)
+	} else if err != nil {
+		b.end(err)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end(err)
+	return err
+}
+
+// end records the body statistics and ends the span, only the first time it
+// is called.
+func (b *tracedBody) end(err error) {
+	b.once.Do(func() {
+		if err != nil {
+			b.span.RecordError(err)
+		}
+		b.span.SetAttributes(
+			attribute.Int64("http.response.body.size", b.read),
+			attribute.Int64("http.response.body.read_ms", time.Since(b.start).Milliseconds()),
+		)
+		b.span.End()
+	})
+}
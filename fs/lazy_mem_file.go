@@ -2,47 +2,73 @@ package fs
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/fs"
+
+	"golang.org/x/net/webdav"
 )
 
 type LazyMemFile struct {
+	ctx      context.Context // context of the request that opened the file
 	reader   *bytes.Reader
 	info     StatikFileInfo
-	populate func() (*bytes.Buffer, error)
+	populate func(context.Context) (*bytes.Buffer, error)
+
+	// if not nil, opens the file to read instead of the contents, when they
+	// are too large to be read in memory
+	tooLarge func() webdav.File
+	large    webdav.File // file opened by tooLarge
 }
 
-func NewLazyMemFile(info StatikFileInfo, populate func() (*bytes.Buffer, error)) *LazyMemFile {
-	return &LazyMemFile{populate: populate, info: info}
+// NewLazyMemFile returns a LazyMemFile that calls populate with ctx the first
+// time it is read or seeked.
+func NewLazyMemFile(ctx context.Context, info StatikFileInfo, populate func(context.Context) (*bytes.Buffer, error)) *LazyMemFile {
+	return &LazyMemFile{ctx: ctx, populate: populate, info: info}
 }
 
-func (m *LazyMemFile) Close() error                       { return nil }
 func (m *LazyMemFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotADir }
 func (m *LazyMemFile) Stat() (fs.FileInfo, error)         { return m.info, nil }
 func (m *LazyMemFile) Write([]byte) (int, error)          { return 0, errReadOnly }
+func (m *LazyMemFile) Close() error {
+	if m.large != nil {
+		return m.large.Close()
+	}
+	return nil
+}
 func (m *LazyMemFile) Read(p []byte) (int, error) {
-	if m.reader == nil {
+	if m.reader == nil && m.large == nil {
 		err := m.load()
 		if err != nil {
 			return 0, err
 		}
 	}
 
+	if m.large != nil {
+		return m.large.Read(p)
+	}
 	return m.reader.Read(p)
 }
 func (m *LazyMemFile) Seek(offset int64, whence int) (int64, error) {
-	if m.reader == nil {
+	if m.reader == nil && m.large == nil {
 		err := m.load()
 		if err != nil {
 			return 0, err
 		}
 	}
 
+	if m.large != nil {
+		return m.large.Seek(offset, whence)
+	}
 	return m.reader.Seek(offset, whence)
 }
 
 func (m *LazyMemFile) load() error {
-	buf, err := m.populate()
-	if err != nil {
+	buf, err := m.populate(m.ctx)
+	if errors.Is(err, ErrUpstreamTooLarge) && m.tooLarge != nil {
+		m.large = m.tooLarge()
+		return nil
+	} else if err != nil {
 		return err
 	}
 
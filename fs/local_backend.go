@@ -0,0 +1,225 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// LocalBackend is a Backend serving a statik tree from a directory of the
+// local filesystem: the listing of each directory is the statik.json file in
+// it, and files are opened directly, without being buffered in memory.
+//
+// Names are resolved inside the root directory, and paths leading outside of
+// it, even through symbolic links, are reported as missing.
+type LocalBackend struct {
+	root string // absolute path of the root directory, with symbolic links resolved
+}
+
+// NewLocalBackend returns a new LocalBackend for the statik tree in the root
+// directory. The directory doesn't have to exist yet.
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err == nil {
+		root = realRoot
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return &LocalBackend{root: root}, nil
+}
+
+// List implements Backend for LocalBackend. The validators of a listing are
+// derived from the modification time and size of its statik.json file.
+func (b *LocalBackend) List(ctx context.Context, path string, prev Validators) (Statik, Validators, error) {
+	name, err := b.resolve(pathpkg.Join(path, "statik.json"))
+	if err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return Statik{}, Validators{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return Statik{}, Validators{}, err
+	}
+
+	validators := fileValidators(stat)
+	if !prev.IsZero() && prev == validators {
+		return Statik{}, prev, ErrNotModified
+	}
+
+	var statik Statik
+	err = json.NewDecoder(file).Decode(&statik)
+	if err != nil {
+		return Statik{}, Validators{}, fmt.Errorf("error decoding statik.json: %w", err)
+	}
+
+	// the urls in statik.json point to the web server of the tree, while files
+	// are read from the directory of the listing
+	for i, file := range statik.Files {
+		if !isLink(file) {
+			statik.Files[i].Url = b.url(pathpkg.Join(path, file.Name()))
+		}
+	}
+
+	return statik, validators, nil
+}
+
+// Stat implements Backend for LocalBackend.
+func (b *LocalBackend) Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error) {
+	name, err := b.filePath(file)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	stat, err := os.Stat(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Validators: fileValidators(stat), Size: stat.Size()}, nil
+}
+
+// Open implements Backend for LocalBackend.
+func (b *LocalBackend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	f, err := b.open(file)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{Validators: fileValidators(stat), Size: stat.Size(), Offset: offset}
+	if !prev.IsZero() && prev == info.Validators {
+		f.Close()
+		return nil, info, ErrNotModified
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	if length < 0 {
+		return f, info, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, info, nil
+}
+
+// OpenFile implements FileOpener for LocalBackend, returning a localFile
+// around the *os.File of file, so that it can be sent with sendfile.
+func (b *LocalBackend) OpenFile(ctx context.Context, file StatikFileInfo) (webdav.File, error) {
+	f, err := b.open(file)
+	if err != nil {
+		return nil, err
+	}
+	return localFile{File: f, info: file}, nil
+}
+
+// localFile is a file opened by a LocalBackend. It is described by the file of
+// the listing, like the files of the other backends, so that its ETag and
+// content type are the ones of the listing. Since it embeds the *os.File, it
+// is still a syscall.Conn, which the response writers send with sendfile.
+type localFile struct {
+	*os.File
+	info StatikFileInfo
+}
+
+func (f localFile) Stat() (fs.FileInfo, error) { return f.info, nil }    // Stat implements fs.File for localFile
+func (f localFile) Write([]byte) (int, error)  { return 0, errReadOnly } // Write implements fs.File for localFile
+
+// open opens the file described by file, which must be a regular file.
+func (b *LocalBackend) open(file StatikFileInfo) (*os.File, error) {
+	name, err := b.filePath(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	} else if !stat.Mode().IsRegular() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: file.Url, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+// url returns the url of the file at the slash-separated path, relative to the
+// root directory.
+func (b *LocalBackend) url(path string) string {
+	return "file://" + filepath.ToSlash(filepath.Join(b.root, filepath.FromSlash(pathpkg.Clean("/"+path))))
+}
+
+// filePath returns the path in the local filesystem of the file described by
+// file, whose url was set by List.
+func (b *LocalBackend) filePath(file StatikFileInfo) (string, error) {
+	prefix := "file://" + strings.TrimSuffix(filepath.ToSlash(b.root), "/")
+	if !strings.HasPrefix(file.Url, prefix+"/") {
+		return "", &fs.PathError{Op: "open", Path: file.Url, Err: fs.ErrNotExist}
+	}
+
+	return b.resolve(strings.TrimPrefix(file.Url, prefix))
+}
+
+// resolve returns the path in the local filesystem of the slash-separated
+// name, relative to the root directory. It fails with fs.ErrNotExist if the
+// name leads outside of the root directory.
+func (b *LocalBackend) resolve(name string) (string, error) {
+	// cleaning a rooted path removes all the ".." elements
+	joined := filepath.Join(b.root, filepath.FromSlash(pathpkg.Clean("/"+name)))
+
+	// symbolic links must not lead outside of the root either
+	real, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(b.root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return real, nil
+}
+
+// fileValidators returns the validators of a file of the local filesystem.
+func fileValidators(stat fs.FileInfo) Validators {
+	return Validators{
+		ETag:         fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime().UTC().Format(http.TimeFormat),
+	}
+}
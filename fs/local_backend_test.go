@@ -0,0 +1,191 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestLocalBackendOpenFile(t *testing.T) {
+	root := t.TempDir()
+	statik := `{"files": [{"name": "a.txt", "url": "https://example.com/a.txt", "mime": "application/x-test", "size": "5 B", "time": "2020-01-01T00:00:00Z"}]}`
+	for name, contents := range map[string]string{"statik.json": statik, "a.txt": "hello"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	ctx := context.Background()
+
+	f, err := m.OpenFile(ctx, "/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// still sent with sendfile
+	if _, ok := f.(syscall.Conn); !ok {
+		t.Errorf("OpenFile = %T, not a syscall.Conn", f)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := info.(StatikFileInfo)
+	if !ok {
+		t.Fatalf("Stat = %T, want StatikFileInfo", info)
+	}
+	wantETag, _ := file.ETag(ctx)
+
+	srv := httptest.NewServer(&webdav.Handler{FileSystem: m, LockSystem: webdav.NewMemLS()})
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/a.txt", nil)
+	req.Header.Set("Range", "bytes=1-3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ell" {
+		t.Errorf("read %q, %v for bytes 1-3", body, err)
+	}
+	if got := resp.Header.Get("ETag"); got != wantETag {
+		t.Errorf("ETag %s, want %s", got, wantETag)
+	}
+
+	req, _ = http.NewRequest("PROPFIND", srv.URL+"/a.txt", nil)
+	req.Header.Set("Depth", "0")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prop := range []string{
+		"<D:getcontenttype>application/x-test</D:getcontenttype>",
+		"<D:getetag>" + wantETag + "</D:getetag>",
+	} {
+		if !strings.Contains(string(body), prop) {
+			t.Errorf("PROPFIND without %s: %s", prop, body)
+		}
+	}
+}
+
+func TestLocalBackendTraversal(t *testing.T) {
+	// root and a directory next to it, which must not be reachable
+	tmp := t.TempDir()
+	root, outside := filepath.Join(tmp, "root"), filepath.Join(tmp, "outside")
+	listing := func(names ...string) string {
+		files := make([]string, len(names))
+		for i, name := range names {
+			files[i] = `{"name": "` + name + `", "url": "https://example.com/f", "size": "6 B"}`
+		}
+		return `{"files": [` + strings.Join(files, ",") + `]}`
+	}
+	for name, contents := range map[string]string{
+		"root/statik.json":    listing("a.txt", "inside.txt", "secret.txt", "../outside/secret.txt", "/etc/passwd"),
+		"root/a.txt":          "hello!",
+		"outside/statik.json": listing("secret.txt"),
+		"outside/secret.txt":  "secret",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(tmp, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"root/inside.txt": filepath.Join(root, "a.txt"),
+		"root/secret.txt": filepath.Join(outside, "secret.txt"),
+		"root/escape":     outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(tmp, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// ".." stops at the root
+	if statik, _, err := b.List(ctx, "/..", Validators{}); err != nil || len(statik.Files) != 5 {
+		t.Errorf("List(/..) = %d files, %v, want the root", len(statik.Files), err)
+	}
+	for _, path := range []string{"/../outside", "/escape", "/a/../../outside"} {
+		if _, _, err := b.List(ctx, path, Validators{}); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("List(%s) = %v, want fs.ErrNotExist", path, err)
+		}
+	}
+
+	// urls out of the root, or resolved out of it
+	for _, url := range []string{
+		"file://" + filepath.ToSlash(outside) + "/secret.txt",
+		"file://" + filepath.ToSlash(root) + "/../outside/secret.txt",
+		"file://" + filepath.ToSlash(root) + "/secret.txt",
+		"file://" + filepath.ToSlash(root) + "/escape/secret.txt",
+		"file://" + filepath.ToSlash(root) + "../outside/secret.txt",
+		"file:///etc/passwd",
+	} {
+		file := StatikFileInfo{NameRaw: "secret.txt", Url: url}
+		if _, err := b.Stat(ctx, file); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%s) = %v, want fs.ErrNotExist", url, err)
+		}
+		if _, _, err := b.Open(ctx, file, 0, -1, Validators{}); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%s) = %v, want fs.ErrNotExist", url, err)
+		}
+	}
+
+	// names of the listing are resolved inside the root, and only the links
+	// staying inside it are followed
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	for name, want := range map[string]string{"/a.txt": "hello!", "/inside.txt": "hello!"} {
+		if got := readAll(t, m, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	statik, _, err := b.List(ctx, "/", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range statik.Files[2:] {
+		if f, err := b.OpenFile(ctx, file); !errors.Is(err, fs.ErrNotExist) {
+			if err == nil {
+				f.Close()
+			}
+			t.Errorf("opening %s at %s = %v, want fs.ErrNotExist", file.Name(), file.Url, err)
+		}
+	}
+	for _, name := range []string{"/secret.txt", "/../outside/secret.txt", "/escape/secret.txt", "/escape"} {
+		if f, err := m.OpenFile(ctx, name, os.O_RDONLY, 0); err == nil {
+			if _, err = io.ReadAll(f); err == nil {
+				t.Errorf("OpenFile(%s) readable", name)
+			}
+			f.Close()
+		}
+	}
+}
@@ -2,19 +2,33 @@ package fs
 
 import (
 	"bytes"
+	"context"
+	"encoding/xml"
 	"fmt"
+	"html"
 	"io/fs"
+	"strings"
 )
 
-type LinkFile struct {
-	i StatikFileInfo
-	*bytes.Reader
+// LinkFormat is the format of the files served for the links of a StatikFS,
+// the text/statik-link entries of its listings.
+type LinkFormat interface {
+	// Ext returns the extension appended to the names of links, such as
+	// ".desktop".
+	Ext() string
+
+	// Render returns the contents of the file of the link called name to url.
+	Render(name, url string) []byte
+
+	// Mime returns the content type of the files of links.
+	Mime() string
 }
 
-func (f LinkFile) Stat() (fs.FileInfo, error)         { return f.i, nil }         // Stat implements fs.File for LinkFile
-func (f LinkFile) Close() error                       { return nil }              // Close implements fs.File for LinkFile
-func (f LinkFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotADir }  // Readdir implements fs.File for LinkFile
-func (f LinkFile) Write([]byte) (int, error)          { return 0, errPermission } // Write implements fs.File for LinkFile
+// DesktopLinkFormat serves links as freedesktop.org .desktop files, which are
+// opened by the file managers of Linux desktops.
+var DesktopLinkFormat LinkFormat = desktopLinkFormat{}
+
+type desktopLinkFormat struct{}
 
 const linkFileTemplate = `[Desktop Entry]
 Type=Link
@@ -24,10 +38,181 @@ URL=%s
 Icon=text-html
 `
 
-func NewLinkFile(info StatikFileInfo) *LinkFile {
-	content := fmt.Sprintf(linkFileTemplate, info.NameRaw, info.Url)
-	info.SizeRaw = fmt.Sprintf("%d", len(content))
-	info.NameRaw += ".desktop"
+func (desktopLinkFormat) Ext() string  { return ".desktop" }              // Ext implements LinkFormat for desktopLinkFormat
+func (desktopLinkFormat) Mime() string { return "application/x-desktop" } // Mime implements LinkFormat for desktopLinkFormat
+
+// Render implements LinkFormat for desktopLinkFormat.
+func (desktopLinkFormat) Render(name, url string) []byte {
+	return []byte(fmt.Sprintf(linkFileTemplate, name, url))
+}
+
+// URLLinkFormat serves links as Windows .url Internet Shortcut files, which
+// are opened by Windows Explorer.
+var URLLinkFormat LinkFormat = urlLinkFormat{}
+
+type urlLinkFormat struct{}
+
+func (urlLinkFormat) Ext() string  { return ".url" }                   // Ext implements LinkFormat for urlLinkFormat
+func (urlLinkFormat) Mime() string { return "application/x-mswinurl" } // Mime implements LinkFormat for urlLinkFormat
+
+// Render implements LinkFormat for urlLinkFormat.
+func (urlLinkFormat) Render(_, url string) []byte {
+	// a line break would end the URL key
+	url = strings.NewReplacer("\r", "", "\n", "").Replace(url)
+	return []byte("[InternetShortcut]\r\nURL=" + url + "\r\n")
+}
+
+// WeblocLinkFormat serves links as macOS .webloc property lists, which are
+// opened by the Finder.
+var WeblocLinkFormat LinkFormat = weblocLinkFormat{}
+
+type weblocLinkFormat struct{}
+
+const weblocTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>URL</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+func (weblocLinkFormat) Ext() string  { return ".webloc" }              // Ext implements LinkFormat for weblocLinkFormat
+func (weblocLinkFormat) Mime() string { return "application/x-webloc" } // Mime implements LinkFormat for weblocLinkFormat
+
+// Render implements LinkFormat for weblocLinkFormat.
+func (weblocLinkFormat) Render(_, url string) []byte {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(url))
+	return []byte(fmt.Sprintf(weblocTemplate, escaped.String()))
+}
+
+// HTMLLinkFormat serves links as .html pages redirecting to the link, which
+// work in any browser.
+var HTMLLinkFormat LinkFormat = htmlLinkFormat{}
+
+type htmlLinkFormat struct{}
+
+const htmlLinkTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url=%[2]s">
+<title>%[1]s</title>
+</head>
+<body>
+<a href="%[2]s">%[1]s</a>
+</body>
+</html>
+`
+
+func (htmlLinkFormat) Ext() string  { return ".html" }                    // Ext implements LinkFormat for htmlLinkFormat
+func (htmlLinkFormat) Mime() string { return "text/html; charset=utf-8" } // Mime implements LinkFormat for htmlLinkFormat
+
+// Render implements LinkFormat for htmlLinkFormat.
+func (htmlLinkFormat) Render(name, url string) []byte {
+	return []byte(fmt.Sprintf(htmlLinkTemplate, html.EscapeString(name), html.EscapeString(url)))
+}
+
+// ParseLinkFormat returns the LinkFormat called name: "desktop", "url",
+// "webloc" or "html".
+func ParseLinkFormat(name string) (LinkFormat, error) {
+	switch name {
+	case "desktop":
+		return DesktopLinkFormat, nil
+	case "url":
+		return URLLinkFormat, nil
+	case "webloc":
+		return WeblocLinkFormat, nil
+	case "html":
+		return HTMLLinkFormat, nil
+	}
+	return nil, fmt.Errorf("unknown link format: %s", name)
+}
+
+// linkFormatKey is the context key of the LinkFormat of a request.
+type linkFormatKey struct{}
+
+// WithRequestLinkFormat returns a copy of ctx in which a StatikFS serves links
+// in format f, instead of the format of the StatikFS.
+func WithRequestLinkFormat(ctx context.Context, f LinkFormat) context.Context {
+	return context.WithValue(ctx, linkFormatKey{}, f)
+}
+
+// isLink reports whether file is a link entry of a listing.
+func isLink(file StatikFileInfo) bool {
+	return file.Mime == "text/statik-link"
+}
+
+// virtualLink returns the info of the file served for the link entry file in
+// format: its name has the extension of the format, and its size and type are
+// the ones of the rendered file. Other files are returned as they are.
+//
+// Listings, Stat and OpenFile all go through virtualLink, so that they agree on
+// the links.
+func virtualLink(format LinkFormat, file StatikFileInfo) StatikFileInfo {
+	if !isLink(file) {
+		return file
+	}
+
+	content := format.Render(file.NameRaw, file.Url)
+	file.NameRaw += format.Ext()
+	file.SizeRaw = fmt.Sprintf("%d B", len(content))
+	file.Mime = format.Mime()
+	return file
+}
+
+// lookupFile returns the entry of statik of the file served as name with the
+// links in format: a file called name, or a link whose name is name without
+// the extension of format. Links are only found by their served name.
+func lookupFile(format LinkFormat, statik Statik, name string) (StatikFileInfo, bool) {
+	if file, found := statik.file(name); found && !isLink(file) {
+		return file, true
+	}
+
+	if strings.HasSuffix(name, format.Ext()) {
+		file, found := statik.file(strings.TrimSuffix(name, format.Ext()))
+		if found && isLink(file) {
+			return file, true
+		}
+	}
+
+	return StatikFileInfo{}, false
+}
+
+// hiddenLink reports whether file is a link of statik hidden by a file with its
+// served name in format, which lookupFile finds instead. Hidden links are left
+// out of the listings, so that no name is listed twice.
+func hiddenLink(format LinkFormat, statik Statik, file StatikFileInfo) bool {
+	if !isLink(file) {
+		return false
+	}
+	other, found := statik.file(file.NameRaw + format.Ext())
+	return found && !isLink(other)
+}
+
+// linkFormat returns the format of the links served for ctx: the one of the
+// request, if set by WithRequestLinkFormat, or the one of the StatikFS.
+func (m *StatikFS) linkFormat(ctx context.Context) LinkFormat {
+	if f, ok := ctx.Value(linkFormatKey{}).(LinkFormat); ok {
+		return f
+	}
+	return m.links
+}
+
+type LinkFile struct {
+	i StatikFileInfo
+	*bytes.Reader
+}
+
+func (f LinkFile) Stat() (fs.FileInfo, error)         { return f.i, nil }         // Stat implements fs.File for LinkFile
+func (f LinkFile) Close() error                       { return nil }              // Close implements fs.File for LinkFile
+func (f LinkFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotADir }  // Readdir implements fs.File for LinkFile
+func (f LinkFile) Write([]byte) (int, error)          { return 0, errPermission } // Write implements fs.File for LinkFile
 
-	return &LinkFile{info, bytes.NewReader([]byte(content))}
+// NewLinkFile returns the file served for the link entry info in format.
+func NewLinkFile(format LinkFormat, info StatikFileInfo) *LinkFile {
+	content := format.Render(info.NameRaw, info.Url)
+	return &LinkFile{virtualLink(format, info), bytes.NewReader(content)}
 }
@@ -0,0 +1,194 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+const (
+	rangeMinWindow = 512 * 1024      // size of the first read-ahead window of a RangeFile
+	rangeMaxWindow = 8 * 1024 * 1024 // maximum size of a read-ahead window of a RangeFile
+)
+
+var errInvalidSeek = errors.New("invalid seek") // a seek is performed to a negative offset
+
+// RangeFile is a webdav.File that streams a file of a Backend by turning Seek
+// and Read calls into range reads, which are HTTP Range requests for an
+// HTTPBackend.
+//
+// Data is fetched in read-ahead windows: the window doubles (up to
+// rangeMaxWindow) while the file is read sequentially, and shrinks back to
+// rangeMinWindow as soon as the reader seeks somewhere else. Only the current
+// window is kept in memory.
+type RangeFile struct {
+	ctx     context.Context // context of the request that opened the file
+	backend Backend
+	info    StatikFileInfo
+
+	size   int64 // exact size of the file, -1 until the backend tells us
+	offset int64 // current read offset
+
+	window      []byte // last fetched window
+	windowStart int64  // offset of window[0] in the file
+	windowSize  int    // size of the next window to fetch
+}
+
+// NewRangeFile returns a RangeFile for the file of backend described by info.
+// No request is made until the file is read or seeked from its end: the
+// requests are made with ctx, so that they are cancelled when the client goes
+// away.
+func NewRangeFile(ctx context.Context, backend Backend, info StatikFileInfo) *RangeFile {
+	return &RangeFile{ctx: ctx, backend: backend, info: info, size: -1, windowSize: rangeMinWindow}
+}
+
+func (f *RangeFile) Close() error                       { f.window = nil; return nil } // Close implements fs.File for RangeFile
+func (f *RangeFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotADir }     // Readdir implements fs.File for RangeFile
+func (f *RangeFile) Stat() (fs.FileInfo, error)         { return f.info, nil }         // Stat implements fs.File for RangeFile
+func (f *RangeFile) Write([]byte) (int, error)          { return 0, errReadOnly }      // Write implements fs.File for RangeFile
+
+// Read implements fs.File for RangeFile.
+func (f *RangeFile) Read(p []byte) (int, error) {
+	if f.size >= 0 && f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if !f.inWindow(f.offset) {
+		if err := f.fetch(f.offset); err != nil {
+			return 0, err
+		}
+		if !f.inWindow(f.offset) {
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, f.window[f.offset-f.windowStart:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// ReadAt implements io.ReaderAt for RangeFile, so that archives can be read
+// without downloading them. It moves the read offset, so it must not be used
+// concurrently with Read and Seek.
+func (f *RangeFile) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek implements fs.File for RangeFile.
+func (f *RangeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		if f.size < 0 {
+			// the size is only known after the first request: probe the
+			// beginning of the file, which is likely to be read next anyway
+			if err := f.fetch(0); err != nil {
+				return 0, err
+			}
+		}
+		offset += f.size
+	default:
+		return 0, errInvalidSeek
+	}
+
+	if offset < 0 {
+		return 0, errInvalidSeek
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *RangeFile) inWindow(offset int64) bool {
+	return offset >= f.windowStart && offset < f.windowStart+int64(len(f.window))
+}
+
+// fetch replaces the current window with the one starting at offset.
+func (f *RangeFile) fetch(offset int64) error {
+	if f.window != nil && offset == f.windowStart+int64(len(f.window)) {
+		// sequential read: read further ahead
+		f.windowSize *= 2
+		if f.windowSize > rangeMaxWindow {
+			f.windowSize = rangeMaxWindow
+		}
+	} else {
+		f.windowSize = rangeMinWindow
+	}
+
+	body, info, err := f.backend.Open(f.ctx, f.info, offset, int64(f.windowSize), Validators{})
+	if err != nil {
+		return recordError(f.ctx, err)
+	}
+	defer body.Close()
+
+	buf, err := readBody(f.info.Url, body, -1)
+	if err != nil {
+		return recordError(f.ctx, err)
+	}
+
+	f.window = buf.Bytes()
+	f.windowStart = info.Offset
+	f.size = info.Size
+	if f.size < 0 && int64(len(f.window)) < int64(f.windowSize) {
+		// a short read tells where the file ends
+		f.size = f.windowStart + int64(len(f.window))
+	}
+	if len(f.window) == 0 {
+		f.window = nil
+	}
+
+	return nil
+}
+
+// parseContentRange parses a Content-Range header in the form "bytes 0-99/1234",
+// "bytes 0-99/*" or "bytes */1234", returning the start of the range and the
+// total size, -1 if unknown.
+func parseContentRange(raw string) (start, size int64, err error) {
+	unit, spec, found := strings.Cut(raw, " ")
+	if !found || unit != "bytes" {
+		return 0, 0, fmt.Errorf("invalid content range: %s", raw)
+	}
+
+	rng, total, found := strings.Cut(spec, "/")
+	if !found || rng == "*" && total == "*" {
+		return 0, 0, fmt.Errorf("invalid content range: %s", raw)
+	}
+
+	size = -1
+	if total != "*" {
+		size, err = strconv.ParseInt(total, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if rng == "*" {
+		return 0, size, nil
+	}
+
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid content range: %s", raw)
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start, size, nil
+}
@@ -0,0 +1,212 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeBackend is a Backend over a memBackend recording the ranges it is asked
+// for. If whole is true, it ignores them and returns whole files; if
+// unknownSize is true, it doesn't tell the size of the files.
+type rangeBackend struct {
+	*memBackend
+	whole       bool
+	unknownSize bool
+	ranges      []string // "offset+length" of each call of Open
+}
+
+// Open implements Backend for rangeBackend.
+func (b *rangeBackend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	b.ranges = append(b.ranges, fmt.Sprintf("%d+%d", offset, length))
+	if b.whole {
+		offset, length = 0, -1
+	}
+
+	body, info, err := b.memBackend.Open(ctx, file, offset, length, prev)
+	if b.unknownSize {
+		info.Size = -1
+	}
+	return body, info, err
+}
+
+// newRangeFileTest returns a rangeBackend serving a file of size bytes, and a
+// RangeFile reading it.
+func newRangeFileTest(size int) (*rangeBackend, *RangeFile, string) {
+	var contents strings.Builder
+	for i := 0; contents.Len() < size; i++ {
+		contents.WriteString(strconv.Itoa(i % 10))
+	}
+
+	b := &rangeBackend{memBackend: newMemBackend(map[string]string{"/f.bin": contents.String()})}
+	file, _ := b.dirs["/"].file("f.bin")
+	return b, NewRangeFile(context.Background(), b, file), contents.String()
+}
+
+func TestRangeFileWindows(t *testing.T) {
+	const size = 4 * rangeMaxWindow
+	b, f, contents := newRangeFileTest(size)
+	defer f.Close()
+
+	// sequential reads double the window up to rangeMaxWindow
+	var buf bytes.Buffer
+	if _, err := io.CopyBuffer(&buf, struct{ io.Reader }{f}, make([]byte, 64*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != contents {
+		t.Fatalf("read %d bytes, want %d", buf.Len(), size)
+	}
+
+	var want []string
+	for offset, window := 0, rangeMinWindow; offset < size; offset += window {
+		if offset > 0 && window < rangeMaxWindow {
+			window *= 2
+		}
+		want = append(want, fmt.Sprintf("%d+%d", offset, window))
+	}
+	if got := strings.Join(b.ranges, " "); got != strings.Join(want, " ") {
+		t.Errorf("sequential ranges = %s, want %s", got, strings.Join(want, " "))
+	}
+
+	// seeking elsewhere starts again from the smallest window
+	b.ranges = nil
+	if _, err := f.Seek(1000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 10)
+	if _, err := io.ReadFull(f, p); err != nil || string(p) != contents[1000:1010] {
+		t.Errorf("read %q, %v at 1000", p, err)
+	}
+	if got := strings.Join(b.ranges, " "); got != fmt.Sprintf("1000+%d", rangeMinWindow) {
+		t.Errorf("ranges after seeking = %s", got)
+	}
+}
+
+func TestRangeFileSeekAndReadAt(t *testing.T) {
+	const size = 3 * rangeMinWindow
+	for _, test := range []struct {
+		name        string
+		whole       bool
+		unknownSize bool
+	}{
+		{"ranges", false, false},
+		{"unknown size", false, true},
+		{"whole files", true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, f, contents := newRangeFileTest(size)
+			b.whole, b.unknownSize = test.whole, test.unknownSize
+			defer f.Close()
+
+			// across the boundary of the first window
+			p := make([]byte, 100)
+			if n, err := f.ReadAt(p, rangeMinWindow-50); err != nil || string(p[:n]) != contents[rangeMinWindow-50:rangeMinWindow+50] {
+				t.Errorf("ReadAt(%d) = %d, %v", rangeMinWindow-50, n, err)
+			}
+
+			// up to the end of the file
+			if n, err := f.ReadAt(p, size-40); err != io.EOF || string(p[:n]) != contents[size-40:] {
+				t.Errorf("ReadAt(%d) = %d, %v, want 40, io.EOF", size-40, n, err)
+			}
+			if n, err := f.Read(p); n != 0 || err != io.EOF {
+				t.Errorf("Read at the end = %d, %v, want io.EOF", n, err)
+			}
+
+			// the size is known, if only from the short read at the end
+			if end, err := f.Seek(-10, io.SeekEnd); err != nil || end != size-10 {
+				t.Errorf("Seek(-10, io.SeekEnd) = %d, %v", end, err)
+			}
+			if rest, err := io.ReadAll(f); err != nil || string(rest) != contents[size-10:] {
+				t.Errorf("read %q, %v at the end", rest, err)
+			}
+
+			if _, err := f.Seek(-1, io.SeekStart); err == nil {
+				t.Error("seeked to a negative offset")
+			}
+			if n, err := f.ReadAt(p, size+10); n != 0 || err != io.EOF {
+				t.Errorf("ReadAt past the end = %d, %v, want io.EOF", n, err)
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, test := range []struct {
+		raw         string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 0-99/1234", 0, 1234, true},
+		{"bytes 100-199/1234", 100, 1234, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes */1234", 0, 1234, true},
+		{"bytes */*", 0, 0, false},
+		{"bytes 0-99", 0, 0, false},
+		{"bytes 0/1234", 0, 0, false},
+		{"bytes x-99/1234", 0, 0, false},
+		{"bytes 0-99/x", 0, 0, false},
+		{"items 0-99/1234", 0, 0, false},
+		{"", 0, 0, false},
+	} {
+		start, size, err := parseContentRange(test.raw)
+		if ok := err == nil; ok != test.ok || ok && (start != test.start || size != test.size) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v", test.raw, start, size, err)
+		}
+	}
+}
+
+func TestRangeFileOverHTTP(t *testing.T) {
+	contents := strings.Repeat("0123456789", 1000)
+
+	for _, test := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"ranges", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "f.bin", time.Time{}, strings.NewReader(contents))
+		}},
+		{"unknown size", func(w http.ResponseWriter, r *http.Request) {
+			var first, last int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last); err != nil {
+				t.Errorf("Range %q: %v", r.Header.Get("Range"), err)
+			}
+			if last >= len(contents) {
+				last = len(contents) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", first, last))
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, contents[first:last+1])
+		}},
+		{"no ranges", func(w http.ResponseWriter, r *http.Request) {
+			// 200 with the whole file
+			io.WriteString(w, contents)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(test.handler)
+			defer srv.Close()
+
+			file := StatikFileInfo{NameRaw: "f.bin", Url: srv.URL + "/f.bin"}
+			f := NewRangeFile(context.Background(), NewHTTPBackend(srv.URL), file)
+			defer f.Close()
+
+			if _, err := f.Seek(5005, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, 10)
+			if _, err := io.ReadFull(f, p); err != nil || string(p) != contents[5005:5015] {
+				t.Errorf("read %q, %v at 5005", p, err)
+			}
+			if rest, err := io.ReadAll(f); err != nil || string(rest) != contents[5015:] {
+				t.Errorf("read %d bytes, %v after 5015", len(rest), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package fs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// S3Config configures the access to the S3-compatible object storage of the
+// s3:// base urls.
+type S3Config struct {
+	Endpoint     string // url of the S3 API, such as https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region       string // region of the buckets, used to sign the requests
+	AccessKey    string // access key id, empty for anonymous requests
+	SecretKey    string // secret access key
+	SessionToken string // session token of temporary credentials, if any
+	PathStyle    bool   // address buckets as endpoint/bucket instead of bucket.endpoint
+}
+
+// DefaultS3Config is the S3Config used until ConfigureS3 is called.
+var DefaultS3Config = S3Config{
+	Endpoint:  "https://s3.amazonaws.com",
+	Region:    "us-east-1",
+	PathStyle: true,
+}
+
+var s3Config = DefaultS3Config
+
+// ConfigureS3 configures the access to the object storage of the s3:// base
+// urls. It must be called before any StatikFS is created.
+func ConfigureS3(cfg S3Config) {
+	s3Config = cfg
+}
+
+// S3Backend is a Backend serving the objects under a prefix of a bucket of an
+// S3-compatible object storage, such as MinIO.
+//
+// Directories are the common prefixes of the keys up to a slash, as listed by
+// ListObjectsV2, and a directory without objects doesn't exist. Files are
+// stat with HEAD requests and read with ranged GET requests, through the
+// client configured by ConfigureHTTP. Requests are signed with AWS Signature
+// Version 4, unless no access key is configured.
+type S3Backend struct {
+	cfg    S3Config
+	bucket string
+	prefix string // key prefix of the root directory, empty or ending with a slash
+}
+
+// NewS3Backend returns a new S3Backend for the objects of bucket whose key
+// starts with prefix.
+func NewS3Backend(cfg S3Config, bucket, prefix string) (*S3Backend, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket name")
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3Backend{cfg: cfg, bucket: bucket, prefix: prefix}, nil
+}
+
+// s3ListResult is the answer to a ListObjectsV2 request.
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// List implements Backend for S3Backend. Listings have no validators.
+func (b *S3Backend) List(ctx context.Context, path string, prev Validators) (Statik, Validators, error) {
+	dirPrefix := b.dirKey(path)
+
+	statik := Statik{StatikDirInfo: b.dirInfo(path)}
+	token := ""
+	for {
+		result, err := b.listObjects(ctx, dirPrefix, token)
+		if err != nil {
+			return Statik{}, Validators{}, err
+		}
+
+		for _, common := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(common.Prefix, dirPrefix), "/")
+			if name != "" {
+				statik.Directories = append(statik.Directories, b.dirInfo(pathpkg.Join(path, name)))
+			}
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, dirPrefix)
+			if name == "" || strings.HasSuffix(name, "/") {
+				// marker object of a directory
+				continue
+			}
+
+			filePath := pathpkg.Join(path, name)
+			statik.Files = append(statik.Files, StatikFileInfo{
+				NameRaw: name,
+				Path:    filePath,
+				Url:     b.objectUrl(b.key(filePath)),
+				Mime:    mimeByExtension(name),
+				SizeRaw: fmt.Sprintf("%d B", object.Size),
+				Time:    object.LastModified,
+			})
+			if object.LastModified.After(statik.Time) {
+				statik.Time = object.LastModified
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	if path != "/" && len(statik.Directories) == 0 && len(statik.Files) == 0 {
+		return Statik{}, Validators{}, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	return statik, Validators{}, nil
+}
+
+// listObjects lists a page of the objects and common prefixes directly under
+// dirPrefix, continuing the listing of token if it is not empty.
+func (b *S3Backend) listObjects(ctx context.Context, dirPrefix, token string) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", "/")
+	query.Set("prefix", dirPrefix)
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	listUrl := b.bucketUrl() + "?" + s3Query(query)
+	header, err := b.sign(http.MethodGet, listUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(ctx, listUrl, header)
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err = checkStatus(listUrl, resp); err != nil {
+		return nil, err
+	}
+
+	var result s3ListResult
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding object listing: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Stat implements Backend for S3Backend.
+func (b *S3Backend) Stat(ctx context.Context, file StatikFileInfo) (ObjectInfo, error) {
+	header, err := b.sign(http.MethodHead, file.Url)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	resp, err := httpRequest(ctx, http.MethodHead, file.Url, header)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err = checkStatus(file.Url, resp); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Validators: responseValidators(resp), Size: resp.ContentLength}, nil
+}
+
+// Open implements Backend for S3Backend.
+func (b *S3Backend) Open(ctx context.Context, file StatikFileInfo, offset, length int64, prev Validators) (io.ReadCloser, ObjectInfo, error) {
+	if length == 0 {
+		info, err := b.Stat(ctx, file)
+		if err != nil {
+			return nil, ObjectInfo{}, err
+		}
+		info.Offset = offset
+		return http.NoBody, info, nil
+	}
+
+	header, err := b.sign(http.MethodGet, file.Url)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	return httpOpen(ctx, file.Url, header, offset, length, prev)
+}
+
+// key returns the key of the object at the slash-separated path, relative to
+// the root directory.
+func (b *S3Backend) key(path string) string {
+	return b.prefix + strings.TrimPrefix(pathpkg.Clean("/"+path), "/")
+}
+
+// dirKey returns the prefix of the keys of the objects in the directory at
+// the slash-separated path, relative to the root directory.
+func (b *S3Backend) dirKey(path string) string {
+	key := b.key(path)
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	return key
+}
+
+// dirInfo returns the StatikDirInfo of the directory at path.
+func (b *S3Backend) dirInfo(path string) StatikDirInfo {
+	return StatikDirInfo{
+		Url:     b.objectUrl(b.dirKey(path)),
+		NameRaw: pathpkg.Base(path),
+		Path:    path,
+		SizeRaw: "0 B",
+	}
+}
+
+// bucketUrl returns the url of the bucket, without a trailing slash.
+func (b *S3Backend) bucketUrl() string {
+	endpoint := strings.TrimSuffix(b.cfg.Endpoint, "/")
+	if b.cfg.PathStyle {
+		return endpoint + "/" + s3Escape(b.bucket)
+	}
+
+	scheme, host, found := strings.Cut(endpoint, "://")
+	if !found {
+		return b.bucket + "." + endpoint
+	}
+	return scheme + "://" + b.bucket + "." + host
+}
+
+// objectUrl returns the url of the object with key.
+func (b *S3Backend) objectUrl(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return b.bucketUrl() + "/" + strings.Join(segments, "/")
+}
+
+// s3Query encodes query as required by AWS Signature Version 4: sorted by key,
+// with all the reserved characters escaped.
+func s3Query(query url.Values) string {
+	// url.Values.Encode sorts the keys, but escapes spaces as "+"
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// s3Escape escapes s as required by AWS Signature Version 4, leaving only the
+// unreserved characters of RFC 3986 unescaped.
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,266 @@
+package fs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an S3-compatible server for the objects of a bucket, answering
+// ListObjectsV2 requests with pages of pageSize entries, and HEAD and ranged
+// GET requests for the objects. Unsigned requests are denied.
+type fakeS3 struct {
+	bucket   string
+	objects  map[string]string // contents of the objects by key
+	pageSize int
+
+	lock   sync.Mutex
+	lists  int      // number of ListObjectsV2 requests
+	ranges []string // Range headers of the GET requests
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if key == "" && r.URL.Query().Get("list-type") == "2" {
+		s.list(w, r)
+		return
+	}
+
+	contents, found := s.objects[key]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.lock.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.lock.Unlock()
+	}
+
+	w.Header().Set("ETag", `"`+strconv.Itoa(len(contents))+`"`)
+	http.ServeContent(w, r, key, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), strings.NewReader(contents))
+}
+
+// list answers a ListObjectsV2 request, whose continuation token is the index
+// of the first entry of the page.
+func (s *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.lists++
+	s.lock.Unlock()
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	type entry struct {
+		key    string
+		common bool
+	}
+	var entries []entry
+	seen := map[string]bool{}
+	for key := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			common := prefix + rest[:i+1]
+			if !seen[common] {
+				seen[common] = true
+				entries = append(entries, entry{common, true})
+			}
+		} else {
+			entries = append(entries, entry{key, false})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := len(entries)
+	if start+s.pageSize < end {
+		end = start + s.pageSize
+	}
+
+	var result s3ListResult
+	if end < len(entries) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	for _, e := range entries[start:end] {
+		if e.common {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct {
+				Prefix string `xml:"Prefix"`
+			}{e.key})
+		} else {
+			result.Contents = append(result.Contents, struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				Size         int64     `xml:"Size"`
+			}{e.key, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), int64(len(s.objects[e.key]))})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: result})
+}
+
+// newFakeS3Backend returns an S3Backend for the objects under prefix of a
+// fakeS3 serving objects.
+func newFakeS3Backend(t *testing.T, objects map[string]string, prefix string) (*S3Backend, *fakeS3) {
+	t.Helper()
+
+	s3 := &fakeS3{bucket: "bucket", objects: objects, pageSize: 2}
+	srv := httptest.NewServer(s3)
+	t.Cleanup(srv.Close)
+
+	cfg := S3Config{Endpoint: srv.URL, Region: "us-east-1", AccessKey: "key", SecretKey: "secret", PathStyle: true}
+	b, err := NewS3Backend(cfg, "bucket", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, s3
+}
+
+var s3TestObjects = map[string]string{
+	"teaching/a.txt":     "hello world",
+	"teaching/b b.txt":   "spaces",
+	"teaching/c.txt":     "c",
+	"teaching/d.txt":     "d",
+	"teaching/sub/":      "",
+	"teaching/sub/e.pdf": "pdfpdf",
+	"teaching/zzz/f.txt": "f",
+	"other/g.txt":        "g",
+}
+
+func TestS3List(t *testing.T) {
+	b, s3 := newFakeS3Backend(t, s3TestObjects, "teaching")
+
+	statik, _, err := b.List(context.Background(), "/", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.lock.Lock()
+	if s3.lists != 3 {
+		t.Errorf("%d pages listed, want 3", s3.lists)
+	}
+	s3.lock.Unlock()
+
+	var files, dirs []string
+	for _, file := range statik.Files {
+		files = append(files, file.Name())
+	}
+	for _, dir := range statik.Directories {
+		dirs = append(dirs, dir.Name())
+	}
+	if got := strings.Join(files, ","); got != "a.txt,b b.txt,c.txt,d.txt" {
+		t.Errorf("files = %s", got)
+	}
+	if got := strings.Join(dirs, ","); got != "sub,zzz" {
+		t.Errorf("directories = %s", got)
+	}
+
+	// the marker object of the directory is not a file
+	statik, _, err = b.List(context.Background(), "/sub", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statik.Files) != 1 || statik.Files[0].Name() != "e.pdf" || statik.Files[0].Size() != 6 {
+		t.Errorf("/sub files = %v", statik.Files)
+	}
+}
+
+func TestS3EmptyPrefix(t *testing.T) {
+	b, _ := newFakeS3Backend(t, s3TestObjects, "teaching")
+
+	_, _, err := b.List(context.Background(), "/missing", Validators{})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("List(/missing) = %v, want fs.ErrNotExist", err)
+	}
+
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	if _, err = m.OpenFile(context.Background(), "/missing/a.txt", os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile(/missing/a.txt) = %v, want fs.ErrNotExist", err)
+	}
+
+	// the root directory exists even without objects
+	b, _ = newFakeS3Backend(t, s3TestObjects, "empty")
+	statik, _, err := b.List(context.Background(), "/", Validators{})
+	if err != nil || len(statik.Files) != 0 || len(statik.Directories) != 0 {
+		t.Errorf("List(/) = %v, %v, want an empty listing", statik, err)
+	}
+}
+
+func TestS3StatAndOpen(t *testing.T) {
+	b, s3 := newFakeS3Backend(t, s3TestObjects, "teaching")
+	ctx := context.Background()
+
+	statik, _, err := b.List(ctx, "/", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file StatikFileInfo
+	for _, f := range statik.Files {
+		if f.Name() == "b b.txt" {
+			file = f
+		}
+	}
+
+	info, err := b.Stat(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 6 || info.ETag != `"6"` {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	body, info, err := b.Open(ctx, file, 2, 3, Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(contents) != "ace" || info.Offset != 2 || info.Size != 6 {
+		t.Errorf("Open = %q, %+v, %v", contents, info, err)
+	}
+	s3.lock.Lock()
+	if len(s3.ranges) != 1 || s3.ranges[0] != "bytes=2-4" {
+		t.Errorf("Range headers = %q", s3.ranges)
+	}
+	s3.lock.Unlock()
+
+	missing := file
+	missing.Url = b.objectUrl("teaching/missing.txt")
+	if _, err = b.Stat(ctx, missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(missing) = %v, want fs.ErrNotExist", err)
+	}
+
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	if got := readAll(t, m, "/sub/e.pdf"); got != "pdfpdf" {
+		t.Errorf("/sub/e.pdf = %q", got)
+	}
+}
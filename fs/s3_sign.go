@@ -0,0 +1,93 @@
+package fs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 of an empty payload
+
+// sign returns the headers signing a request without body with method to
+// rawUrl with AWS Signature Version 4, or nil if no access key is configured.
+//
+// The url must be escaped as done by objectUrl and s3Query. The signature is
+// valid for 15 minutes, so it can be reused by the retries of the request.
+func (b *S3Backend) sign(method, rawUrl string) (http.Header, error) {
+	return b.signAt(method, rawUrl, time.Now())
+}
+
+// signAt is sign for a request made at time now.
+func (b *S3Backend) signAt(method, rawUrl string, now time.Time) (http.Header, error) {
+	if b.cfg.AccessKey == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	header := http.Header{}
+	header.Set("X-Amz-Date", amzDate)
+	header.Set("X-Amz-Content-Sha256", s3EmptyHash)
+
+	canonicalHeaders := "host:" + u.Host + "\n" +
+		"x-amz-content-sha256:" + s3EmptyHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if b.cfg.SessionToken != "" {
+		header.Set("X-Amz-Security-Token", b.cfg.SessionToken)
+		canonicalHeaders += "x-amz-security-token:" + b.cfg.SessionToken + "\n"
+		signedHeaders += ";x-amz-security-token"
+	}
+
+	canonicalUri := u.EscapedPath()
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalUri,
+		u.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyHash,
+	}, "\n")
+
+	scope := date + "/" + b.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+b.cfg.SecretKey), date)
+	key = hmacSHA256(key, b.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	header.Set("Authorization", "AWS4-HMAC-SHA256 "+
+		"Credential="+b.cfg.AccessKey+"/"+scope+", "+
+		"SignedHeaders="+signedHeaders+", "+
+		"Signature="+signature)
+
+	return header, nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
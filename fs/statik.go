@@ -1,46 +1,153 @@
 package fs
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"io/fs"
 	"time"
+
+	"golang.org/x/net/webdav"
 )
 
 type Statik struct {
 	StatikDirInfo
 	Directories []StatikDirInfo  `json:"directories"`
 	Files       []StatikFileInfo `json:"files"`
+
+	index *statikIndex // index of the entries by name, nil until built by indexed
 }
 
-func (s Statik) Write([]byte) (int, error)      { return 0, errReadOnly } // Write implements fs.File for Statik
-func (s Statik) Seek(int64, int) (int64, error) { return 0, errReadOnly } // Seek implements fs.File for Statik
-func (s Statik) Read([]byte) (int, error)       { return 0, errReadOnly } // Read implements fs.File for Statik
-func (s Statik) Close() error                   { return nil }            // Close implements fs.File for Statik
-func (s Statik) Stat() (fs.FileInfo, error)     { return s, nil }         // Stat implements fs.File for Statik
-func (s Statik) Readdir(count int) ([]fs.FileInfo, error) {
+// statikIndex maps the names of the entries of a Statik to their position in
+// Files and Directories.
+//
+// If several entries have the same name, the first one is indexed. Links are
+// indexed by their name in the listing, see lookupFile for their served name.
+type statikIndex struct {
+	files map[string]int
+	dirs  map[string]int
+}
 
-	if count <= 0 || count > len(s.Directories)+len(s.Files) {
-		count = len(s.Directories) + len(s.Files)
+// indexed returns a copy of s with an index of its entries, so that they can be
+// looked up by name in constant time. Listings must be indexed once, before
+// they are shared.
+func (s Statik) indexed() Statik {
+	index := &statikIndex{
+		files: make(map[string]int, len(s.Files)),
+		dirs:  make(map[string]int, len(s.Directories)),
 	}
 
-	infos := make([]fs.FileInfo, count)
-
+	for i, file := range s.Files {
+		if _, found := index.files[file.Name()]; !found {
+			index.files[file.Name()] = i
+		}
+	}
 	for i, dir := range s.Directories {
-		if i == count {
-			return infos, nil
+		if _, found := index.dirs[dir.Name()]; !found {
+			index.dirs[dir.Name()] = i
 		}
-		infos[i] = dir
 	}
 
-	for i, file := range s.Files {
-		arrIdx := i + len(s.Directories)
-		if arrIdx == count {
-			return infos, nil
+	s.index = index
+	return s
+}
+
+// file returns the file of s called name.
+func (s Statik) file(name string) (StatikFileInfo, bool) {
+	if s.index != nil {
+		i, found := s.index.files[name]
+		if !found {
+			return StatikFileInfo{}, false
+		}
+		return s.Files[i], true
+	}
+
+	for _, file := range s.Files {
+		if file.Name() == name {
+			return file, true
+		}
+	}
+	return StatikFileInfo{}, false
+}
+
+// dir returns the subdirectory of s called name.
+func (s Statik) dir(name string) (StatikDirInfo, bool) {
+	if s.index != nil {
+		i, found := s.index.dirs[name]
+		if !found {
+			return StatikDirInfo{}, false
+		}
+		return s.Directories[i], true
+	}
+
+	for _, dir := range s.Directories {
+		if dir.Name() == name {
+			return dir, true
+		}
+	}
+	return StatikDirInfo{}, false
+}
+
+// statikDir is an open directory of a StatikFS, whose entries are listed by a
+// Statik, with the links served in a LinkFormat. Like an os.File, it keeps a
+// cursor across Readdir calls.
+type statikDir struct {
+	statik Statik
+	links  LinkFormat
+	read   int // number of entries already returned by Readdir
+}
+
+func newStatikDir(statik Statik, links LinkFormat) *statikDir {
+	return &statikDir{statik: statik, links: links}
+}
+
+func (d *statikDir) Write([]byte) (int, error)  { return 0, errReadOnly } // Write implements fs.File for statikDir
+func (d *statikDir) Read([]byte) (int, error)   { return 0, errReadOnly } // Read implements fs.File for statikDir
+func (d *statikDir) Close() error               { return nil }            // Close implements fs.File for statikDir
+func (d *statikDir) Stat() (fs.FileInfo, error) { return d.statik, nil }  // Stat implements fs.File for statikDir
+
+// Seek implements fs.File for statikDir. A directory can only be rewound, to
+// read its entries again from the first one.
+func (d *statikDir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errReadOnly
+	}
+	d.read = 0
+	return 0, nil
+}
+
+// Readdir implements fs.File for statikDir, listing directories first, and
+// links as the files served for them, except for the links hidden by a file
+// with the same served name. As in os.File, if count > 0 it returns at most
+// count entries, and io.EOF at the end of the directory; otherwise it returns
+// all the remaining entries.
+func (d *statikDir) Readdir(count int) ([]fs.FileInfo, error) {
+	dirs, files := d.statik.Directories, d.statik.Files
+
+	remaining := len(dirs) + len(files) - d.read
+	if count > 0 && remaining == 0 {
+		return nil, io.EOF
+	}
+	size := remaining
+	if count > 0 && count < remaining {
+		size = count
+	}
+
+	infos := make([]fs.FileInfo, 0, size)
+	for ; len(infos) < size && d.read < len(dirs)+len(files); d.read++ {
+		if d.read < len(dirs) {
+			infos = append(infos, dirs[d.read])
+		} else if file := files[d.read-len(dirs)]; !hiddenLink(d.links, d.statik, file) {
+			infos = append(infos, virtualLink(d.links, file))
 		}
-		infos[arrIdx] = file
 	}
 
+	if count > 0 && len(infos) == 0 {
+		return nil, io.EOF
+	}
 	return infos, nil
-} // Readdir implements fs.File for Statik
+}
 
 // StatikDirInfo represents a directory in Statik.
 type StatikDirInfo struct {
@@ -50,6 +157,8 @@ type StatikDirInfo struct {
 	NameRaw     string    `json:"name"`
 	Path        string    `json:"path"`
 	SizeRaw     string    `json:"size"`
+
+	tag bool // the directory of a tag of a git repository, holding another version of the whole tree
 }
 
 func (d StatikDirInfo) Mode() fs.FileMode  { return fs.ModeDir }                 // Mode implements fs.FileInfo for StatikDirInfo
@@ -59,6 +168,12 @@ func (d StatikDirInfo) Sys() any           { return nil }
 func (d StatikDirInfo) Name() string       { return d.NameRaw }                  // Name implements fs.FileInfo for StatikDirInfo
 func (d StatikDirInfo) Size() int64        { return parseSizeOrZero(d.SizeRaw) } // Size implements fs.FileInfo for StatikDirInfo
 
+// ETag implements webdav.ETager for StatikDirInfo. The ETag is weak, as the
+// listing of a directory can change without its time and size changing.
+func (d StatikDirInfo) ETag(context.Context) (string, error) {
+	return "W/" + etag(d.Url, d.Time, d.Size()), nil
+}
+
 type StatikFileInfo struct {
 	NameRaw string    `json:"name"`
 	Path    string    `json:"path"`
@@ -66,6 +181,8 @@ type StatikFileInfo struct {
 	Mime    string    `json:"mime"`
 	SizeRaw string    `json:"size"`
 	Time    time.Time `json:"time"`
+
+	archive archiveFormat // format of the archive the file is a member of, archiveNone if it isn't
 }
 
 func (f StatikFileInfo) Name() string       { return f.NameRaw }                  // Name implements fs.FileInfo for StatikFileInfo
@@ -74,3 +191,35 @@ func (f StatikFileInfo) ModTime() time.Time { return f.Time }
 func (f StatikFileInfo) IsDir() bool        { return false }                      // IsDir implements fs.FileInfo for StatikFileInfo
 func (f StatikFileInfo) Sys() any           { return nil }                        // Sys implements fs.FileInfo for StatikFileInfo
 func (f StatikFileInfo) Size() int64        { return parseSizeOrZero(f.SizeRaw) } // Size implements fs.FileInfo for StatikFileInfo
+
+// ContentType implements webdav.ContentTyper for StatikFileInfo, so that the
+// content type is never sniffed from the contents of the file.
+func (f StatikFileInfo) ContentType(context.Context) (string, error) {
+	if f.Mime == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return f.Mime, nil
+}
+
+// ETag implements webdav.ETager for StatikFileInfo. The ETag is strong if
+// the time of the file is known, and weak otherwise.
+func (f StatikFileInfo) ETag(context.Context) (string, error) {
+	tag := etag(f.Url, f.Time, f.Size())
+	if f.Time.IsZero() {
+		tag = "W/" + tag
+	}
+	return tag, nil
+}
+
+// etag returns the opaque tag of the contents at url, changed at time t and of
+// size bytes, quoted.
+func etag(url string, t time.Time, size int64) string {
+	var nanos int64
+	if !t.IsZero() {
+		nanos = t.UnixNano()
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(url))
+	return fmt.Sprintf(`"%x-%x-%x"`, h.Sum64(), nanos, size)
+}
@@ -1,93 +1,425 @@
 package fs
 
 import (
+	"container/list"
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
+	"io/fs"
+	pathpkg "path"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// statikCache is a struct that represents a cache of statik.json files.
-type statikCache struct {
-	baseUrl   string
-	cache     map[string]statikCacheEl
-	cacheLock sync.RWMutex
+const (
+	DefaultStatikCacheSize = 64 * 1024 * 1024 // default byte budget of a StatikCache
+
+	statikNegativeTime  = 30 * time.Second // how long to remember that a statik.json is missing
+	statikSweepInterval = time.Minute      // how often dead entries are removed from a StatikCache
+	statikEntryOverhead = 128              // rough size of the fixed fields of a cached entry, in bytes
+)
+
+// StatikCache is a cache of statik.json files with a total byte budget, meant
+// to be shared by all the StatikFS of a server. The size of each entry is
+// estimated from the strings it holds.
+//
+// Expired entries are kept for a grace period, during which they are still
+// served while they are refreshed in the background. If the refresh fails,
+// the stale entry keeps being served until the grace period is over, so that
+// directories don't disappear while the remote server is down. Entries past
+// their grace period are swept periodically.
+//
+// Missing statik.json files are cached too, for a shorter time, so that the
+// constant probing of WebDAV clients for files like .DS_Store or desktop.ini
+// is answered locally.
+//
+// When the cache is full, entries of the teachings using more than their fair
+// share of the budget are evicted first, least recently used first.
+//
+// The StatikCache is goroutine-safe.
+type StatikCache struct {
+	maxBytes int64         // total byte budget
+	grace    time.Duration // how long expired entries can be served
+
+	lock       sync.Mutex
+	used       int64                    // estimated bytes currently cached
+	items      map[string]*list.Element // cached entries by url
+	lru        *list.List               // of *statikCacheEl, most recently used first
+	owners     map[string]int64         // estimated bytes cached by base url
+	refreshing map[string]bool          // urls being refreshed in the background
+	flights    flightGroup[*statikCacheEl]
+
+	stop     chan struct{} // closed to stop the sweeper
+	stopOnce sync.Once
 }
 
-func newStatikCache(baseUrl string) *statikCache {
-	return &statikCache{
-		baseUrl: baseUrl,
-		cache:   make(map[string]statikCacheEl),
+// NewStatikCache returns an empty StatikCache holding up to maxBytes bytes, and
+// serving expired entries for up to staleTime.
+func NewStatikCache(maxBytes int64, staleTime time.Duration) *StatikCache {
+	c := &StatikCache{
+		maxBytes:   maxBytes,
+		grace:      staleTime,
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		owners:     make(map[string]int64),
+		refreshing: make(map[string]bool),
+		stop:       make(chan struct{}),
 	}
+
+	go c.sweeper()
+	return c
+}
+
+// Close stops the background sweeping of the cache. It can be called more
+// than once.
+func (c *StatikCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
 }
 
 // statikCacheEl represents a cached statik.json file and its expiration time.
 type statikCacheEl struct {
-	statik Statik
-	exp    time.Time
+	url        string // url of the directory, key of the entry
+	owner      string // base url of the teaching the entry belongs to
+	size       int64  // estimated size of the entry, in bytes
+	statik     Statik
+	missing    bool // the statik.json doesn't exist upstream
+	exp        time.Time
+	validators Validators // validators of the listing, used to revalidate the entry
+}
+
+// statikCache is the view of a StatikCache for the teaching at baseUrl, whose
+// listings come from backend.
+type statikCache struct {
+	*StatikCache
+	baseUrl string
+	backend Backend
+}
+
+func newStatikCache(shared *StatikCache, baseUrl string, backend Backend) *statikCache {
+	shared.lock.Lock()
+	if _, found := shared.owners[baseUrl]; !found {
+		shared.owners[baseUrl] = 0
+	}
+	shared.lock.Unlock()
+
+	return &statikCache{StatikCache: shared, baseUrl: baseUrl, backend: backend}
 }
 
 // Get returns the Statik struct for the statik.json file in the directory
 // specified by path.
 //
-// If the statik.json file is not cached, it is fetched from the remote server,
-// cached and returned.
+// If the statik.json file is not cached, it is fetched from the backend, cached
+// and returned. If the cached statik.json is expired, it is revalidated with a
+// conditional request and only downloaded again if it changed.
 //
-// The function is safe for concurrent use, as it uses a RW mutex to protect the
+// The function is safe for concurrent use, as it uses a mutex to protect the
 // cache.
 func (m *statikCache) Get(ctx context.Context, path string) (Statik, error) {
 	ctx, span := tr.Start(ctx, "statik-cache.Get")
 	span.SetAttributes(attribute.String("path", path))
 	defer span.End()
 
+	url := m.baseUrl + path
+
 	// check cache
-	m.cacheLock.RLock()
-	cache, contentOk := m.cache[path]
-	m.cacheLock.RUnlock()
+	cache, contentOk := m.lookup(url)
 
-	if contentOk && cache.exp.After(time.Now()) {
+	now := time.Now()
+	if contentOk && cache.exp.After(now) {
 		span.AddEvent("cache hit")
 
+		if cache.missing {
+			return Statik{}, notFound(path)
+		}
+		return cache.statik, nil
+	} else if contentOk && !cache.missing && cache.exp.Add(m.grace).After(now) {
+		span.AddEvent("cache stale")
+
+		m.refresh(ctx, path, cache)
 		return cache.statik, nil
 	} else if contentOk {
 		span.AddEvent("cache expired")
+	} else if m.missingFromParent(path) {
+		span.AddEvent("missing from parent")
+
+		return Statik{}, notFound(path)
+	} else {
+		// cache miss
+		log.Debug().Str("path", path).Msg("statik cache miss")
+		span.AddEvent("cache miss")
+	}
+
+	if cache != nil && cache.missing {
+		// there is nothing to revalidate
+		cache = nil
+	}
+
+	el, err := m.flights.Do(ctx, url, func(ctx context.Context) (*statikCacheEl, error) {
+		return m.fetch(ctx, path, cache)
+	})
+	if errors.Is(err, ErrCircuitOpen) && cache != nil {
+		// the upstream is known to be down: a stale listing is better than none
+		span.AddEvent("circuit open, serving stale")
+
+		return cache.statik, nil
+	} else if err != nil {
+		return Statik{}, err
+	}
+
+	// populate cache
+	m.store(el)
+	span.AddEvent("statik.json cached")
+
+	if el.missing {
+		return Statik{}, notFound(path)
+	}
+	return el.statik, nil
+}
+
+// missingFromParent reports whether the fresh cached listing of the parent of
+// path shows that path doesn't exist.
+func (m *statikCache) missingFromParent(path string) bool {
+	parentPath := pathpkg.Dir(path)
+	if parentPath == path {
+		return false
+	}
+
+	parent, found := m.lookup(m.baseUrl + parentPath)
+	if !found || parent.missing || !parent.exp.After(time.Now()) {
+		return false
+	}
+
+	name := pathpkg.Base(path)
+	if _, found := parent.statik.dir(name); found {
+		return false
+	}
+	if _, found := parent.statik.file(name); found && archiveFormatOf(name) != archiveNone {
+		// archives are browsed as directories
+		return false
+	}
+	return true
+}
+
+// notFound returns the error for a missing statik.json in the directory
+// specified by path.
+func notFound(path string) error {
+	return &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+}
+
+// refresh revalidates the stale entry prev for path in the background, unless
+// it is already being refreshed. On failure, prev is left in the cache.
+func (m *statikCache) refresh(ctx context.Context, path string, prev *statikCacheEl) {
+	m.lock.Lock()
+	if m.refreshing[prev.url] {
+		m.lock.Unlock()
+		return
+	}
+	m.refreshing[prev.url] = true
+	m.lock.Unlock()
 
-		m.cacheLock.Lock()
-		delete(m.cache, path)
-		m.cacheLock.Unlock()
+	trace.SpanFromContext(ctx).AddEvent("background refresh started")
+
+	// the refresh must outlive the request that triggered it
+	ctx, span := tr.Start(context.Background(), "statik-cache.refresh",
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("path", path)))
+
+	go func() {
+		defer span.End()
+
+		el, err := m.flights.Do(ctx, prev.url, func(ctx context.Context) (*statikCacheEl, error) {
+			return m.fetch(ctx, path, prev)
+		})
+
+		m.lock.Lock()
+		delete(m.refreshing, prev.url)
+		m.lock.Unlock()
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to refresh statik.json")
+			log.Warn().Err(err).Str("path", path).Msg("serving stale statik.json")
+			return
+		}
+
+		m.store(el)
+		span.AddEvent("statik.json cached")
+	}()
+}
+
+// fetch gets the statik.json file in the directory specified by path from the
+// backend.
+//
+// If prev is not nil, the request is conditional on the validators of prev,
+// and a copy of prev is returned with a new expiration time if the backend
+// answers with ErrNotModified.
+func (m *statikCache) fetch(ctx context.Context, path string, prev *statikCacheEl) (*statikCacheEl, error) {
+	span := trace.SpanFromContext(ctx)
+
+	var validators Validators
+	if prev != nil {
+		validators = prev.validators
+	}
+
+	url := m.baseUrl + path
+	statik, validators, err := m.backend.List(ctx, path, validators)
+	if errors.Is(err, fs.ErrNotExist) {
+		span.AddEvent("statik.json not found")
+
+		return &statikCacheEl{
+			url:     url,
+			owner:   m.baseUrl,
+			size:    statikEntryOverhead + int64(len(url)),
+			missing: true,
+			exp:     time.Now().Add(statikNegativeTime),
+		}, nil
 	}
 
-	// cache miss
-	log.Debug().Str("path", path).Msg("statik cache miss")
-	span.AddEvent("cache miss")
+	if prev != nil && errors.Is(err, ErrNotModified) {
+		span.AddEvent("statik.json not modified")
 
-	response, err := httpGet(ctx, m.baseUrl+path+"/statik.json")
+		el := *prev
+		el.exp = time.Now().Add(StatikCachingTime)
+		return &el, nil
+	}
 	if err != nil {
-		return Statik{}, fmt.Errorf("error getting statik.json: %w", err)
+		return nil, err
 	}
 	span.AddEvent("statik.json fetched")
 
-	var statik Statik
-	err = json.NewDecoder(response.Body).Decode(&statik)
-	if err != nil {
-		return Statik{}, fmt.Errorf("error decoding statik.json: %w", err)
+	return &statikCacheEl{
+		url:        url,
+		owner:      m.baseUrl,
+		size:       statikSize(statik),
+		statik:     statik.indexed(),
+		exp:        time.Now().Add(StatikCachingTime),
+		validators: validators,
+	}, nil
+}
+
+// put caches statik as the fresh listing of the directory specified by path,
+// with validators.
+func (m *statikCache) put(path string, statik Statik, validators Validators) {
+	m.store(&statikCacheEl{
+		url:        m.baseUrl + path,
+		owner:      m.baseUrl,
+		size:       statikSize(statik),
+		statik:     statik.indexed(),
+		exp:        time.Now().Add(StatikCachingTime),
+		validators: validators,
+	})
+}
+
+// lookup returns the cached entry for url, marking it as recently used. The
+// returned entry must not be modified.
+func (c *StatikCache) lookup(url string) (*statikCacheEl, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, found := c.items[url]
+	if !found {
+		return nil, false
 	}
-	span.AddEvent("statik.json decoded")
 
-	err = response.Body.Close()
-	if err != nil {
-		return Statik{}, fmt.Errorf("error closing response body: %w", err)
+	c.lru.MoveToFront(el)
+	return el.Value.(*statikCacheEl), true
+}
+
+// store caches el, replacing the entry with the same url, and evicts entries
+// until the cache fits in its budget.
+func (c *StatikCache) store(el *statikCacheEl) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if old, found := c.items[el.url]; found {
+		c.remove(old)
 	}
 
-	// populate cache
-	m.cacheLock.Lock()
-	m.cache[path] = statikCacheEl{statik, time.Now().Add(StatikCachingTime)}
-	m.cacheLock.Unlock()
-	span.AddEvent("statik.json cached")
+	c.items[el.url] = c.lru.PushFront(el)
+	c.used += el.size
+	c.owners[el.owner] += el.size
+
+	for c.used > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.victim())
+	}
+}
+
+// victim returns the entry to evict: the least recently used entry of a
+// teaching above its fair share of the budget if any, or the least recently
+// used entry otherwise. The lock must be held.
+func (c *StatikCache) victim() *list.Element {
+	share := c.maxBytes / int64(len(c.owners))
+
+	for el := c.lru.Back(); el != nil; el = el.Prev() {
+		if c.owners[el.Value.(*statikCacheEl).owner] > share {
+			return el
+		}
+	}
+
+	return c.lru.Back()
+}
+
+// remove removes el from the cache. The lock must be held.
+func (c *StatikCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*statikCacheEl)
+	delete(c.items, entry.url)
+	c.used -= entry.size
+	c.owners[entry.owner] -= entry.size
+}
+
+// sweeper periodically removes the entries past their grace period, until the
+// cache is closed.
+func (c *StatikCache) sweeper() {
+	ticker := time.NewTicker(statikSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.sweep(now)
+		}
+	}
+}
+
+// sweep removes the entries past their grace period at time now.
+func (c *StatikCache) sweep(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	swept := 0
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		entry := el.Value.(*statikCacheEl)
+		if !entry.exp.After(now) && (entry.missing || !entry.exp.Add(c.grace).After(now)) {
+			c.remove(el)
+			swept++
+		}
+		el = prev
+	}
+
+	if swept > 0 {
+		log.Debug().Int("swept", swept).Int("entries", c.lru.Len()).Int64("bytes", c.used).Msg("statik cache swept")
+	}
+}
+
+// statikSize estimates the memory used by s, in bytes.
+func statikSize(s Statik) int64 {
+	size := statikDirInfoSize(s.StatikDirInfo)
+	for _, dir := range s.Directories {
+		size += statikDirInfoSize(dir)
+	}
+	for _, file := range s.Files {
+		size += statikEntryOverhead + int64(len(file.NameRaw)+len(file.Path)+len(file.Url)+len(file.Mime)+len(file.SizeRaw))
+	}
+	return size
+}
 
-	return statik, nil
+func statikDirInfoSize(d StatikDirInfo) int64 {
+	return statikEntryOverhead + int64(len(d.Url)+len(d.NameRaw)+len(d.Path)+len(d.SizeRaw))
 }
@@ -0,0 +1,196 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// httpFS is an http.FileSystem serving the files of a webdav.FileSystem.
+type httpFS struct{ webdav.FileSystem }
+
+// Open implements http.FileSystem for httpFS.
+func (h httpFS) Open(name string) (http.File, error) {
+	return h.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+}
+
+// newTestStatik returns a listing of the directories a and b, the files c.txt
+// and d.txt, and the link e.
+func newTestStatik() Statik {
+	return Statik{
+		Directories: []StatikDirInfo{{NameRaw: "a"}, {NameRaw: "b"}},
+		Files: []StatikFileInfo{
+			{NameRaw: "c.txt", Url: "mem:///c.txt", SizeRaw: "1 B"},
+			{NameRaw: "d.txt", Url: "mem:///d.txt", SizeRaw: "1 B"},
+			{NameRaw: "e", Url: "https://example.com", Mime: "text/statik-link"},
+		},
+	}
+}
+
+// names returns the names of infos, comma-separated.
+func names(infos []fs.FileInfo) string {
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func TestStatikDirReaddir(t *testing.T) {
+	d := newStatikDir(newTestStatik(), DesktopLinkFormat)
+	const all = "a,b,c.txt,d.txt,e.desktop"
+
+	// pages of count entries, then io.EOF
+	var pages []string
+	for {
+		infos, err := d.Readdir(2)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, names(infos))
+	}
+	if got := strings.Join(pages, "|"); got != "a,b|c.txt,d.txt|e.desktop" {
+		t.Errorf("Readdir(2) pages = %s", got)
+	}
+	if infos, err := d.Readdir(0); err != nil || len(infos) != 0 {
+		t.Errorf("Readdir(0) at the end = %s, %v", names(infos), err)
+	}
+
+	if _, err := d.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := d.Readdir(1); err != nil || names(infos) != "a" {
+		t.Errorf("Readdir(1) after rewinding = %s, %v", names(infos), err)
+	}
+	if infos, err := d.Readdir(-1); err != nil || names(infos) != "b,c.txt,d.txt,e.desktop" {
+		t.Errorf("Readdir(-1) = %s, %v", names(infos), err)
+	}
+
+	if _, err := d.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := d.Readdir(0); err != nil || names(infos) != all {
+		t.Errorf("Readdir(0) after rewinding = %s, %v", names(infos), err)
+	}
+	if _, err := d.Seek(1, io.SeekStart); err == nil {
+		t.Error("seeked a directory to 1")
+	}
+}
+
+func TestStatikDirServe(t *testing.T) {
+	b := newMemBackend(map[string]string{
+		"/a/x.txt": "x",
+		"/b/y.txt": "y",
+		"/c.txt":   "c",
+		"/d.txt":   "d",
+	})
+	root := b.dirs["/"]
+	root.Files = append(root.Files, StatikFileInfo{NameRaw: "e", Url: "https://example.com", Mime: "text/statik-link"})
+	b.dirs["/"] = root
+	m := newMemStatikFS(t, b, StatikStaleTime)
+	want := []string{"a", "b", "c.txt", "d.txt", "e.desktop"}
+
+	dav := httptest.NewServer(&webdav.Handler{FileSystem: m, LockSystem: webdav.NewMemLS()})
+	defer dav.Close()
+
+	req, _ := http.NewRequest("PROPFIND", dav.URL+"/", nil)
+	req.Header.Set("Depth", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(body), "<D:response>"); n != len(want)+1 {
+		t.Errorf("PROPFIND Depth: 1 = %d responses, want %d: %s", n, len(want)+1, body)
+	}
+	for _, href := range []string{"/a/", "/b/", "/c.txt", "/d.txt", "/e.desktop"} {
+		if !strings.Contains(string(body), "<D:href>"+href+"</D:href>") {
+			t.Errorf("PROPFIND Depth: 1 without %s", href)
+		}
+	}
+
+	files := httptest.NewServer(http.FileServer(httpFS{m}))
+	defer files.Close()
+
+	resp, err = http.Get(files.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET / = %d, %v", resp.StatusCode, err)
+	}
+	for _, name := range want {
+		if !strings.Contains(string(body), ">"+name) {
+			t.Errorf("file server listing without %s: %s", name, body)
+		}
+	}
+}
+
+func TestStatikDirHiddenLink(t *testing.T) {
+	statik := Statik{Files: []StatikFileInfo{
+		{NameRaw: "x", Url: "https://example.com", Mime: "text/statik-link"},
+		{NameRaw: "x.desktop", Url: "mem:///x.desktop", SizeRaw: "1 B"},
+		{NameRaw: "y", Url: "https://example.com", Mime: "text/statik-link"},
+	}}.indexed()
+
+	d := newStatikDir(statik, DesktopLinkFormat)
+	if infos, err := d.Readdir(0); err != nil || names(infos) != "x.desktop,y.desktop" {
+		t.Errorf("Readdir(0) = %s, %v", names(infos), err)
+	}
+
+	// the hidden link doesn't make a page empty
+	d.Seek(0, io.SeekStart)
+	var pages []string
+	for {
+		infos, err := d.Readdir(1)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, names(infos))
+	}
+	if got := strings.Join(pages, "|"); got != "x.desktop|y.desktop" {
+		t.Errorf("Readdir(1) pages = %s", got)
+	}
+
+	if file, found := lookupFile(DesktopLinkFormat, statik, "x.desktop"); !found || isLink(file) {
+		t.Errorf("x.desktop = %+v, %t, want the file", file, found)
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, n := range []int{10, 1000, 100000} {
+		statik := Statik{Files: make([]StatikFileInfo, n)}
+		for i := range statik.Files {
+			statik.Files[i] = StatikFileInfo{NameRaw: fmt.Sprintf("%d.pdf", i)}
+		}
+		// a link, served as last.desktop
+		statik.Files[n-1] = StatikFileInfo{NameRaw: "last", Url: "https://example.com", Mime: "text/statik-link"}
+		statik = statik.indexed()
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, found := lookupFile(DesktopLinkFormat, statik, "last.desktop"); !found {
+					b.Fatal("last.desktop not found")
+				}
+			}
+		})
+	}
+}
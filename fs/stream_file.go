@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+)
+
+// StreamFile is a webdav.File that reads a file of a Backend as a single
+// stream, for the backends that can only read a file from its start, where the
+// windows of a RangeFile would read the file again and again.
+//
+// Seeking forward skips the contents in between, seeking backward opens the
+// file again: the whole file is read once when it is read sequentially, even
+// from an offset, as done when serving a Range request.
+type StreamFile struct {
+	ctx     context.Context // context of the request that opened the file
+	backend Backend
+	info    StatikFileInfo
+
+	size   int64 // exact size of the file, -1 until the backend tells us
+	offset int64 // current read offset
+
+	stream    io.ReadCloser // contents of the file from streamPos, nil until the file is read
+	streamPos int64         // offset of the next byte of stream in the file
+}
+
+// NewStreamFile returns a StreamFile for the file of backend described by info.
+// The file is opened with ctx when it is first read or seeked from its end.
+func NewStreamFile(ctx context.Context, backend Backend, info StatikFileInfo) *StreamFile {
+	return &StreamFile{ctx: ctx, backend: backend, info: info, size: -1}
+}
+
+func (f *StreamFile) Readdir(int) ([]fs.FileInfo, error) { return nil, errNotADir } // Readdir implements fs.File for StreamFile
+func (f *StreamFile) Stat() (fs.FileInfo, error)         { return f.info, nil }     // Stat implements fs.File for StreamFile
+func (f *StreamFile) Write([]byte) (int, error)          { return 0, errReadOnly }  // Write implements fs.File for StreamFile
+
+// Close implements fs.File for StreamFile.
+func (f *StreamFile) Close() error {
+	if f.stream == nil {
+		return nil
+	}
+
+	err := f.stream.Close()
+	f.stream = nil
+	return err
+}
+
+// Read implements fs.File for StreamFile.
+func (f *StreamFile) Read(p []byte) (int, error) {
+	if f.size >= 0 && f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.stream != nil && f.streamPos > f.offset {
+		// seeked backward
+		_ = f.Close()
+	}
+	if f.stream == nil {
+		if err := f.open(f.offset); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.streamPos < f.offset {
+		// seeked forward
+		n, err := io.CopyN(io.Discard, f.stream, f.offset-f.streamPos)
+		f.streamPos += n
+		if err != nil {
+			return 0, recordError(f.ctx, err)
+		}
+	}
+
+	n, err := f.stream.Read(p)
+	f.offset += int64(n)
+	f.streamPos += int64(n)
+	if err != nil && err != io.EOF {
+		err = recordError(f.ctx, err)
+	}
+	return n, err
+}
+
+// Seek implements fs.File for StreamFile.
+func (f *StreamFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		if f.size < 0 {
+			// the size is only known once the file is opened: open it
+			// where it is likely to be read next anyway
+			if err := f.open(f.offset); err != nil {
+				return 0, err
+			}
+		}
+		offset += f.size
+	default:
+		return 0, errInvalidSeek
+	}
+
+	if offset < 0 {
+		return 0, errInvalidSeek
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// open replaces the current stream with one starting at offset, or before it
+// if the backend can't start there.
+func (f *StreamFile) open(offset int64) error {
+	_ = f.Close()
+
+	body, info, err := f.backend.Open(f.ctx, f.info, offset, -1, Validators{})
+	if err != nil {
+		return recordError(f.ctx, err)
+	}
+
+	f.stream = body
+	f.streamPos = info.Offset
+	if info.Size >= 0 {
+		f.size = info.Size
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamFile(t *testing.T) {
+	const contents = "0123456789abcdefghij"
+	b := newMemBackend(map[string]string{"/a.txt": contents})
+	file, _ := b.dirs["/"].file("a.txt")
+
+	f := NewStreamFile(context.Background(), b, file)
+	defer f.Close()
+
+	read := func(n int) string {
+		t.Helper()
+		p := make([]byte, n)
+		n, err := io.ReadFull(f, p)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		return string(p[:n])
+	}
+
+	if size, err := f.Seek(0, io.SeekEnd); err != nil || size != int64(len(contents)) {
+		t.Fatalf("Seek(0, io.SeekEnd) = %d, %v", size, err)
+	}
+	if _, err := f.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(3); got != "234" {
+		t.Errorf("read %q at 2", got)
+	}
+	if _, err := f.Seek(5, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(20); got != "abcdefghij" {
+		t.Errorf("read %q at 10", got)
+	}
+	if _, opens := b.calls(); opens != 1 {
+		t.Errorf("file opened %d times reading forward, want 1", opens)
+	}
+
+	if _, err := f.Seek(1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(2); got != "12" {
+		t.Errorf("read %q at 1", got)
+	}
+	if _, opens := b.calls(); opens != 2 {
+		t.Errorf("file opened %d times after seeking backward, want 2", opens)
+	}
+
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeked to a negative offset")
+	}
+}
+
+func TestStreamFileServeContent(t *testing.T) {
+	contents := strings.Repeat("0123456789", 1000)
+	b := newMemBackend(map[string]string{"/a.txt": contents})
+	file, _ := b.dirs["/"].file("a.txt")
+
+	for _, rng := range []string{"", "bytes=0-9", "bytes=5000-5009", "bytes=9990-"} {
+		f := NewStreamFile(context.Background(), b, file)
+
+		req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, req, "a.txt", time.Time{}, f)
+		f.Close()
+
+		want := contents
+		switch rng {
+		case "bytes=0-9", "bytes=5000-5009", "bytes=9990-":
+			want = "0123456789"
+		}
+		if got := rec.Body.String(); got != want {
+			t.Errorf("Range %q: got %d bytes, want %q", rng, len(got), want)
+		}
+	}
+
+	if _, opens := b.calls(); opens != 4 {
+		t.Errorf("file opened %d times for 4 requests", opens)
+	}
+}
@@ -4,7 +4,6 @@ go 1.19
 
 require (
 	github.com/gorilla/handlers v1.5.1
-	github.com/hashicorp/golang-lru/v2 v2.0.7
 	github.com/rs/zerolog v1.31.0
 	github.com/spf13/cobra v1.7.0
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.45.0
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/csunibo/fileseeker/fs"
+)
+
+// userAgentLinkFormats are the link formats of the clients recognized by
+// LinkFormatByUserAgent, by User-Agent substring. WebDAV clients come before
+// browsers, since some of them present themselves as browsers.
+var userAgentLinkFormats = []struct {
+	agent  string
+	format fs.LinkFormat
+}{
+	{"Microsoft-WebDAV-MiniRedir", fs.URLLinkFormat}, // Windows Explorer
+	{"WebDAVFS", fs.WeblocLinkFormat},                // macOS Finder
+	{"WebDAVLib", fs.WeblocLinkFormat},               // macOS Finder
+	{"gvfs", fs.DesktopLinkFormat},                   // GNOME Files
+	{"davfs2", fs.DesktopLinkFormat},                 // davfs2 mounts
+	{"KIO", fs.DesktopLinkFormat},                    // KDE Dolphin
+	{"Mozilla", fs.HTMLLinkFormat},                   // browsers
+}
+
+// LinkFormatByUserAgent wraps the handler of a StatikFS, so that links are
+// served in the format opened by the client of each request, as told by its
+// User-Agent: .url files for Windows Explorer, .webloc files for macOS Finder,
+// .desktop files for Linux desktops and .html pages for browsers. Unknown
+// clients get the format of the StatikFS.
+//
+// Since the format of every response, even the default one of unknown clients,
+// depends on the User-Agent, the responses vary on it for caches.
+func LinkFormatByUserAgent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "User-Agent")
+
+		agent := req.UserAgent()
+		for _, client := range userAgentLinkFormats {
+			if strings.Contains(agent, client.agent) {
+				req = req.WithContext(fs.WithRequestLinkFormat(req.Context(), client.format))
+				break
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
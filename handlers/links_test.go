@@ -0,0 +1,27 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/csunibo/fileseeker/handlers"
+)
+
+func TestLinkFormatByUserAgentVary(t *testing.T) {
+	handler := handlers.LinkFormatByUserAgent(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}))
+
+	for _, agent := range []string{"Microsoft-WebDAV-MiniRedir/10.0", "Mozilla/5.0", "curl/8.0", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		req.Header.Set("User-Agent", agent)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		vary := rec.Header().Values("Vary")
+		if len(vary) != 2 || vary[0] != "User-Agent" || vary[1] != "Accept-Encoding" {
+			t.Errorf("User-Agent %q: Vary = %q, want User-Agent and Accept-Encoding", agent, vary)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/csunibo/fileseeker/fs"
+)
+
+// UpstreamStatus wraps a webdav handler serving a StatikFS, so that requests
+// failing because of the upstream server are answered with the matching
+// status (404, 403, 502, 503 or 504) instead of the generic one chosen by the
+// webdav handler.
+func UpstreamStatus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(fs.WithErrorRecorder(req.Context()))
+		next.ServeHTTP(&upstreamStatusWriter{ResponseWriter: w, req: req}, req)
+	})
+}
+
+// upstreamStatusWriter is a http.ResponseWriter replacing error statuses with
+// the one matching the upstream error recorded for req, if any.
+type upstreamStatusWriter struct {
+	http.ResponseWriter
+	req *http.Request
+}
+
+func (w *upstreamStatusWriter) WriteHeader(status int) {
+	switch status {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusInternalServerError:
+		if upstreamStatus := fs.HTTPStatus(fs.RecordedError(w.req.Context())); upstreamStatus != 0 {
+			status = upstreamStatus
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ReadFrom lets the underlying http.ResponseWriter send files served from
+// disk with sendfile, if it can.
+func (w *upstreamStatusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// http.ResponseWriter can.
+func (w *upstreamStatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for
+// http.ResponseController.
+func (w *upstreamStatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,31 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/csunibo/fileseeker/handlers"
+)
+
+func TestUpstreamStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := handlers.UpstreamStatus(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("writer not an http.Flusher")
+		}
+		w.Write([]byte("partial"))
+		f.Flush()
+
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok || u.Unwrap() != rec {
+			t.Error("writer not unwrapping to the underlying one")
+		}
+	}))
+
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !rec.Flushed || rec.Body.String() != "partial" {
+		t.Errorf("flushed %t, body %q", rec.Flushed, rec.Body.String())
+	}
+}
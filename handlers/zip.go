@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/csunibo/fileseeker/fs"
+)
+
+// ZipConfig limits the directories downloaded as zip archives by ZipDownload.
+type ZipConfig struct {
+	MaxSize  int64 // maximum total size of the files of a download, in bytes
+	MaxFiles int   // maximum number of files of a download
+}
+
+// DefaultZipConfig is the default ZipConfig.
+var DefaultZipConfig = ZipConfig{
+	MaxSize:  1024 * 1024 * 1024,
+	MaxFiles: 5000,
+}
+
+var errZipTooLarge = errors.New("directory too large to be downloaded") // a zip download exceeds the limits
+
+// zipFile is a file to be added to a zip download.
+type zipFile struct {
+	path string // path of the file in the StatikFS
+	name string // name of the file in the archive
+}
+
+// ZipDownload wraps the handler of statikFS, served under prefix, so that GET
+// requests for a directory with the download=zip query, such as
+// /<teaching>/2023/?download=zip, are answered with a zip archive of all the
+// files in the directory and in its subdirectories. Other requests are passed
+// to next.
+//
+// The tree is walked before answering, so that directories exceeding the
+// limits of cfg are refused upfront. Files are then fetched one at a time and
+// streamed in the archive as they arrive, stored without compression since
+// they are mostly documents that are already compressed. Since listings may
+// understate the sizes of the files, the download is aborted as soon as the
+// bytes sent exceed the size limit anyway. Links are added as the files served
+// for them by statikFS.
+func ZipDownload(next http.Handler, statikFS *fs.StatikFS, prefix string, cfg ZipConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("download") != "zip" || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		root := path.Clean("/" + strings.TrimPrefix(req.URL.Path, prefix))
+		archiveName := path.Base(root)
+		if root == "/" {
+			archiveName = path.Base(prefix)
+		}
+
+		var (
+			files []zipFile
+			size  int64
+		)
+		err := statikFS.Walk(req.Context(), root, func(name string, file fs.StatikFileInfo) error {
+			files = append(files, zipFile{
+				path: name,
+				name: path.Join(archiveName, strings.TrimPrefix(name, root)),
+			})
+			size += file.Size()
+
+			if len(files) > cfg.MaxFiles {
+				return fmt.Errorf("%w: more than %d files", errZipTooLarge, cfg.MaxFiles)
+			} else if size > cfg.MaxSize {
+				return fmt.Errorf("%w: more than %d MiB", errZipTooLarge, cfg.MaxSize>>20)
+			}
+			return nil
+		})
+		if err != nil {
+			zipError(w, err)
+			return
+		}
+
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": archiveName + ".zip"})
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", disposition)
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		// the sizes of the listings may be wrong: the limit is enforced on
+		// the bytes actually sent too
+		var sent int64
+		archive := zip.NewWriter(w)
+		for _, file := range files {
+			n, err := writeZipFile(req, archive, statikFS, file, cfg.MaxSize-sent)
+			sent += n
+			if err != nil {
+				// the status is gone already: make the client see a
+				// broken download instead of a truncated archive
+				log.Error().Err(err).Str("path", file.path).Msg("zip download failed")
+				panic(http.ErrAbortHandler)
+			}
+		}
+
+		if err = archive.Close(); err != nil {
+			log.Error().Err(err).Str("path", root).Msg("zip download failed")
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// zipError answers a zip download that can't start because of err. Upstream
+// errors are answered with their status by UpstreamStatus.
+func zipError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errZipTooLarge):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, iofs.ErrNotExist):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		log.Error().Err(err).Msg("zip download failed")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeZipFile adds file of statikFS to archive, failing with errZipTooLarge
+// if it is bigger than limit bytes. It returns the number of bytes written.
+func writeZipFile(req *http.Request, archive *zip.Writer, statikFS *fs.StatikFS, file zipFile, limit int64) (int64, error) {
+	f, err := statikFS.OpenFile(req.Context(), file.path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	header := &zip.FileHeader{Name: file.name, Method: zip.Store, Modified: info.ModTime()}
+	header.SetMode(info.Mode())
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(entry, io.LimitReader(f, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("%w: more bytes than listed", errZipTooLarge)
+	}
+	return n, err
+}
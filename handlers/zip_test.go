@@ -0,0 +1,126 @@
+package handlers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/csunibo/fileseeker/fs"
+	"github.com/csunibo/fileseeker/handlers"
+)
+
+// newUpstream returns a server of a statik tree with a.txt and sub/b.txt,
+// whose listings claim that the files are 1 byte long.
+func newUpstream(t *testing.T, contents string) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimLeft(r.URL.Path, "/") {
+		case "t/statik.json", "t//statik.json":
+			io.WriteString(w, `{"directories": [{"name": "sub"}], "files": [{"name": "a.txt", "url": "`+srv.URL+`/t/a.txt", "size": "1 B", "mime": "text/plain"}]}`)
+		case "t/sub/statik.json":
+			io.WriteString(w, `{"files": [{"name": "b.txt", "url": "`+srv.URL+`/t/sub/b.txt", "size": "1 B", "mime": "text/plain"}]}`)
+		case "t/a.txt", "t/sub/b.txt":
+			io.WriteString(w, contents)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newZipServer returns a server of the zip downloads of the statik tree at
+// upstream, limited by cfg.
+func newZipServer(t *testing.T, upstream string, cfg handlers.ZipConfig) *httptest.Server {
+	t.Helper()
+
+	statikFS, err := fs.NewStatikFS(upstream + "/t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { statikFS.Close() })
+
+	dav := &webdav.Handler{Prefix: "/t", FileSystem: statikFS, LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(handlers.ZipDownload(dav, statikFS, "/t", cfg))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestZipDownload(t *testing.T) {
+	upstream := newUpstream(t, "x")
+	srv := newZipServer(t, upstream.URL, handlers.DefaultZipConfig)
+
+	resp, err := http.Get(srv.URL + "/t/?download=zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d, Content-Type %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "t/a.txt,t/sub/b.txt" {
+		t.Errorf("archive files = %s", got)
+	}
+}
+
+func TestZipDownloadLimits(t *testing.T) {
+	upstream := newUpstream(t, "x")
+	srv := newZipServer(t, upstream.URL, handlers.ZipConfig{MaxSize: 1 << 20, MaxFiles: 1})
+
+	resp, err := http.Get(srv.URL + "/t/?download=zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status %d for too many files, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestZipDownloadUnderstatedSizes(t *testing.T) {
+	// the listings claim 2 bytes in total
+	upstream := newUpstream(t, strings.Repeat("x", 1000))
+	srv := newZipServer(t, upstream.URL, handlers.ZipConfig{MaxSize: 1500, MaxFiles: 10})
+
+	// the connection is closed, before or after the headers are sent
+	resp, err := http.Get(srv.URL + "/t/?download=zip")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		t.Fatal("download not aborted")
+	}
+	if _, err = zip.NewReader(bytes.NewReader(body), int64(len(body))); err == nil {
+		t.Error("aborted download is a valid archive")
+	}
+	if len(body) > 1500+1024 {
+		t.Errorf("%d bytes sent, more than the limit", len(body))
+	}
+}
@@ -2,6 +2,7 @@ package listfs
 
 import (
 	"context"
+	"io"
 	"io/fs"
 	"os"
 	"strings"
@@ -23,10 +24,12 @@ type (
 		modTime time.Time
 	}
 
-	// listRoot is a webdav.File that is the root directory of a ListFS
+	// listRoot is a webdav.File that is the root directory of a ListFS,
+	// keeping a cursor across Readdir calls
 	listRoot struct {
 		listFile
 		children []string
+		read     int // number of children already returned by Readdir
 	}
 )
 
@@ -39,7 +42,7 @@ func (f ListFS) RemoveAll(context.Context, string) error          { return fs.Er
 func (f ListFS) Rename(context.Context, string, string) error     { return fs.ErrPermission } // Rename implements webdav.FileSystem for ListFS
 func (f ListFS) OpenFile(_ context.Context, name string, _ int, _ os.FileMode) (webdav.File, error) {
 	if name == "/" {
-		return listRoot{
+		return &listRoot{
 			listFile: listFile{name: "", modTime: f.modTimes},
 			children: f.names,
 		}, nil
@@ -80,15 +83,28 @@ func (l listFile) Write([]byte) (int, error)          { return 0, fs.ErrPermissi
 func (l listFile) Stat() (fs.FileInfo, error)         { return l, nil }                // Stat implements fs.File for listFile
 func (l listFile) Readdir(int) ([]fs.FileInfo, error) { return nil, fs.ErrPermission } // Readdir implements fs.File for listFile
 
-func (l listRoot) Readdir(count int) ([]fs.FileInfo, error) {
-	if count <= 0 || count > len(l.children) {
-		count = len(l.children)
+func (l *listRoot) Readdir(count int) ([]fs.FileInfo, error) {
+	remaining := len(l.children) - l.read
+	if count > 0 && remaining == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > remaining {
+		count = remaining
 	}
 
 	files := make([]fs.FileInfo, count)
-	for i := 0; i < count; i++ {
-		files[i] = listFile{name: l.children[i], modTime: l.modTime}
+	for i := range files {
+		files[i] = listFile{name: l.children[l.read+i], modTime: l.modTime}
 	}
+	l.read += count
 
 	return files, nil
-} // Readdir implements fs.ReadDirFile for listRoot
+} // Readdir implements fs.File for listRoot, with the semantics of os.File.Readdir
+
+func (l *listRoot) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, fs.ErrPermission
+	}
+	l.read = 0
+	return 0, nil
+} // Seek implements fs.File for listRoot, rewinding the directory only
@@ -0,0 +1,124 @@
+package listfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// httpFS is an http.FileSystem serving the files of a webdav.FileSystem.
+type httpFS struct{ webdav.FileSystem }
+
+// Open implements http.FileSystem for httpFS.
+func (h httpFS) Open(name string) (http.File, error) {
+	return h.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+}
+
+var testNames = []string{"algebra", "analisi", "fisica", "logica", "reti"}
+
+// names returns the names of infos, comma-separated.
+func names(infos []fs.FileInfo) string {
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func TestListRootReaddir(t *testing.T) {
+	root, err := NewListFS(testNames).OpenFile(context.Background(), "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	// pages of count entries, then io.EOF
+	var pages []string
+	for {
+		infos, err := root.Readdir(2)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, names(infos))
+	}
+	if got := strings.Join(pages, "|"); got != "algebra,analisi|fisica,logica|reti" {
+		t.Errorf("Readdir(2) pages = %s", got)
+	}
+	if infos, err := root.Readdir(0); err != nil || len(infos) != 0 {
+		t.Errorf("Readdir(0) at the end = %s, %v", names(infos), err)
+	}
+
+	if _, err := root.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := root.Readdir(1); err != nil || names(infos) != "algebra" {
+		t.Errorf("Readdir(1) after rewinding = %s, %v", names(infos), err)
+	}
+	if infos, err := root.Readdir(-1); err != nil || names(infos) != "analisi,fisica,logica,reti" {
+		t.Errorf("Readdir(-1) = %s, %v", names(infos), err)
+	}
+
+	if _, err := root.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := root.Readdir(0); err != nil || names(infos) != strings.Join(testNames, ",") {
+		t.Errorf("Readdir(0) after rewinding = %s, %v", names(infos), err)
+	}
+	if _, err := root.Seek(1, io.SeekStart); err == nil {
+		t.Error("seeked the root to 1")
+	}
+}
+
+func TestListRootServe(t *testing.T) {
+	list := NewListFS(testNames)
+
+	dav := httptest.NewServer(&webdav.Handler{FileSystem: list, LockSystem: webdav.NewMemLS()})
+	defer dav.Close()
+
+	req, _ := http.NewRequest("PROPFIND", dav.URL+"/", nil)
+	req.Header.Set("Depth", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(body), "<D:response>"); n != len(testNames)+1 {
+		t.Errorf("PROPFIND Depth: 1 = %d responses, want %d: %s", n, len(testNames)+1, body)
+	}
+	for _, name := range testNames {
+		if !strings.Contains(string(body), "<D:displayname>"+name+"</D:displayname>") {
+			t.Errorf("PROPFIND Depth: 1 without %s", name)
+		}
+	}
+
+	files := httptest.NewServer(http.FileServer(httpFS{list}))
+	defer files.Close()
+
+	resp, err = http.Get(files.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET / = %d, %v", resp.StatusCode, err)
+	}
+	for _, name := range testNames {
+		if !strings.Contains(string(body), ">"+name+"/<") {
+			t.Errorf("file server listing without %s/: %s", name, body)
+		}
+	}
+}